@@ -0,0 +1,95 @@
+package main
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+)
+
+var asnDb *mmdbReader // ASN database of -asn-db, nil without it
+
+// Unique IPs of one autonomous system
+type AsnCount struct {
+	Asn          uint32 `json:"asn"`
+	Organization string `json:"organization"`
+	Count        uint64 `json:"count"`
+}
+
+// Unique IPs grouped by the autonomous systems of the -asn-db database
+type AsnReport struct {
+	Top      []AsnCount `json:"top"`      // Systems with the most addresses, at most -asn-top of them
+	Distinct int        `json:"distinct"` // Systems with at least one address
+	Unknown  uint64     `json:"unknown"`  // Addresses the database has no system for
+}
+
+// Function which looks up the autonomous system of every address of the set and reports the top systems
+// The data record of every network is decoded once, the addresses share it through its offset,
+// and the addresses of a network found by the previous walk of the tree skip the walk
+// With -network-bits every network is looked up by its first address like in classCounts
+func asnCounts(set Set, networkBits int, db *mmdbReader, top int) AsnReport {
+	report := AsnReport{}
+	systems := map[uint32]*AsnCount{}
+	records := map[int]*AsnCount{} // Record offset to its system, nil for the records without a number
+	var failed error
+	invalid := uint64(0)
+
+	cache := mmdbCache{db: db}
+	shift := uint(32 - networkBits)
+	set.ForEach(func(network uint32) {
+		offset, ok, err := cache.lookup(network << shift)
+		if err == nil && ok {
+			system, seen := records[offset]
+			if !seen {
+				if system, err = asnRecord(db, offset, systems); err == nil {
+					records[offset] = system
+				}
+			}
+			if system != nil {
+				system.Count++
+				return
+			}
+		}
+		if err != nil {
+			failed = err
+			invalid++
+		}
+		report.Unknown++
+	})
+	if failed != nil {
+		slog.Warn("ASN database lookups failed, the addresses are counted as unknown", "addresses", invalid, "err", failed)
+	}
+	slog.Debug("ASN database lookups", "addresses", set.Count(), "tree_walks", cache.walks)
+
+	for _, system := range systems {
+		if system.Count > 0 {
+			report.Top = append(report.Top, *system)
+		}
+	}
+	report.Distinct = len(report.Top)
+	slices.SortFunc(report.Top, func(a, b AsnCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Asn, b.Asn))
+	})
+	report.Top = report.Top[:min(top, len(report.Top))]
+	return report
+}
+
+// Function which decodes the record of the GeoLite2-ASN layout, the system of its number is shared by all
+// the records with the same number, nil when the record has no number
+func asnRecord(db *mmdbReader, offset int, systems map[uint32]*AsnCount) (*AsnCount, error) {
+	value, _, err := db.data.decode(offset)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]any)
+	number, ok := record["autonomous_system_number"].(uint64)
+	if !ok {
+		return nil, nil
+	}
+	system, ok := systems[uint32(number)]
+	if !ok {
+		organization, _ := record["autonomous_system_organization"].(string)
+		system = &AsnCount{Asn: uint32(number), Organization: organization}
+		systems[uint32(number)] = system
+	}
+	return system, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"Lightspeed_Task/ipcount"
+)
+
+const (
+	AUTO_ARRAY_BYTES = 8 * 1024 * 1024 // Bitsets up to this size (-network-bits 26 or less) are always arrays
+	AUTO_HASHSET_MAX = 1 << 20         // Estimated unique count up to which -backend auto picks the hash set
+	AUTO_ROARING_MAX = 1 << 25         // Estimated unique count up to which -backend auto picks the roaring set
+)
+
+// Function which allocates the set of the chosen backend
+// The array is allocated in full here, so -warmup pre-faults its pages before the reading starts
+// A failed allocation can't be recovered in Go (the runtime aborts, or the OOM killer ends the process
+// once the pages are touched), so the array is only allocated when the system and the cgroup have the
+// memory for it. Otherwise -backend auto falls back to the roaring set and an explicit array is an error
+func newSet(config Config) (Set, error) {
+	backend := config.backend
+	if backend == "auto" {
+		var err error
+		backend, err = chooseBackend(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if backend == "array" {
+		arrayBytes := uint64(bitsetWords(config.networkBits)) * 4
+		if available, ok := availableMemory(); ok && arrayBytes > available {
+			if config.backend != "auto" {
+				return nil, fmt.Errorf("the array bitset needs %d MB but only %d MB of memory are available, use -approx for an estimate in 64KB or -backend sparse, roaring or hashset", arrayBytes>>20, available>>20)
+			}
+			slog.Warn("not enough memory for the array bitset, the roaring set is used", "needed_mb", arrayBytes>>20, "available_mb", available>>20)
+			backend = "roaring"
+		}
+	}
+
+	switch backend {
+	case "array":
+		dense := NewIPSet(bitsetWords(config.networkBits))
+		// with one reading thread and no live snapshots (-progress-json, -watch) nothing else touches the words,
+		// the SIGUSR1 count of such a set waits for the reading to finish (see handleCountSignal)
+		dense.single = config.numThreads == 1 && config.progressPath == "" && config.watch == 0
+		if config.warmup {
+			warmupStart := time.Now()
+			warmupUint32Arr(dense.words)
+			slog.Info("bitset warmup finished", "elapsed", time.Since(warmupStart))
+		}
+		return dense, nil
+	case "sparse":
+		return ipcount.NewSparseSet(), nil
+	case "roaring":
+		return newRoaringSet(), nil
+	case "hashset":
+		return newHashSet(), nil
+	case "approx":
+		return newApproxSet(), nil
+	}
+	return nil, fmt.Errorf("unknown set backend %q, expected auto, array, sparse, roaring or hashset", backend)
+}
+
+// Function which picks the backend for -backend auto from the estimated unique count of the input
+// The estimate is the -estimate-first sample of every file, an upper bound, so small inputs aren't
+// mistaken for large ones. Inputs which can't be sampled (compressed or followed files) use the array,
+// which has the same cost for any number of addresses
+func chooseBackend(config Config) (string, error) {
+	if bitsetWords(config.networkBits)*4 <= AUTO_ARRAY_BYTES {
+		return "array", nil
+	}
+	if config.follow {
+		slog.Info("followed input keeps growing, the array backend is used")
+		return "array", nil
+	}
+
+	estimate := uint64(len(config.addresses))
+	for _, path := range config.filePaths {
+		compression, err := detectCompression(path)
+		if err != nil {
+			return "", err
+		}
+		if compression != "" {
+			slog.Info("compressed input can't be sampled, the array backend is used", "file", path)
+			return "array", nil
+		}
+		size, err := getFileSize(path)
+		if err != nil {
+			return "", err
+		}
+		fileEstimate, err := estimateUniqueIps(config, path, size)
+		if err != nil {
+			return "", err
+		}
+		estimate += fileEstimate
+	}
+
+	backend := "array"
+	if estimate <= AUTO_HASHSET_MAX {
+		backend = "hashset"
+	} else if estimate <= AUTO_ROARING_MAX {
+		backend = "roaring"
+	}
+	slog.Info("selected set backend", "backend", backend, "estimate", estimate)
+	return backend, nil
+}
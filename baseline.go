@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"Lightspeed_Task/ipcount"
+)
+
+var baseline Set // Addresses of the -baseline dump, nil without -baseline
+
+// Addresses which appeared and disappeared since the -baseline dump
+type BaselineChange struct {
+	Added   uint64 `json:"added"`   // Addresses of the input which are not in the baseline
+	Removed uint64 `json:"removed"` // Addresses of the baseline which are not in the input
+}
+
+// Function which reads the -write-binary file of an earlier run into a sparse set
+// The file may be compressed like the inputs. Raw records are taken as they are, only a partial record
+// fails the run; a checksummed dump (-write-binary-checksum) also fails it with a corrupted frame or
+// when it's truncated, so a damaged dump never reports made-up changes
+func readBaseline(name string, networkShift uint) (Set, error) {
+	compression, err := detectCompression(name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = bufio.NewReaderSize(file, BUFFER_SIZE)
+	if compression != "" {
+		decompressed, err := decompressReader(reader, compression)
+		if err != nil {
+			return nil, err
+		}
+		defer decompressed.Close()
+		reader = decompressed
+	}
+
+	reader, _, err = streamRecords(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	set := ipcount.NewSparseSet()
+	buf := make([]byte, BUFFER_SIZE) // a multiple of the record size, so no record is split between two reads
+	for {
+		n, err := io.ReadFull(reader, buf)
+		for i := 0; i+BINARY_RECORD_SIZE <= n; i += BINARY_RECORD_SIZE {
+			set.Add(binary.BigEndian.Uint32(buf[i:]) >> networkShift)
+		}
+		switch {
+		case err == nil:
+			continue
+		case err != io.EOF && err != io.ErrUnexpectedEOF:
+			return nil, fmt.Errorf("baseline %s: %w", name, err)
+		case n%BINARY_RECORD_SIZE != 0:
+			return nil, fmt.Errorf("baseline %s ends with a partial record, it's not a -write-binary file", name)
+		}
+		return set, nil
+	}
+}
+
+// Function which counts the addresses added to and removed from the baseline in one pass over each set
+func baselineChange(current Set, base Set) BaselineChange {
+	change := BaselineChange{}
+	current.ForEach(func(network uint32) {
+		if !base.Contains(network) {
+			change.Added++
+		}
+	})
+	base.ForEach(func(network uint32) {
+		if !current.Contains(network) {
+			change.Removed++
+		}
+	})
+	return change
+}
+
+// Function which collects the addresses of from which are not in other, for the dumps of
+// -baseline-added and -baseline-removed
+func setDifference(from Set, other Set) Set {
+	difference := ipcount.NewSparseSet()
+	from.ForEach(func(network uint32) {
+		if !other.Contains(network) {
+			difference.Add(network)
+		}
+	})
+	return difference
+}
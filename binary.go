@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+)
+
+const BINARY_RECORD_SIZE = 4 // Bytes of one -binary record, the address as a big-endian uint32
+
+var binaryRecords atomic.Uint64  // Whole records read from the -binary inputs
+var partialRecords atomic.Uint64 // Bytes at the ends of the -binary inputs too short for a whole record
+
+// Function which reads the records of the byte range of the plain -binary file into the set
+// The records have a fixed width and the chunks are aligned to it (see splitJobs), so unlike
+// the lines no chunk needs to look at the bytes of its neighbours
+// The range of a dump holds whole frames, every chunk checks the checksums of its own frames
+func binaryFileRead(ctx context.Context, config Config, job chunkJob) error {
+	file, err := chunkOpener(config, job.path)(job.offset)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = io.LimitReader(file, int64(job.length))
+	if job.dump {
+		reader = newDumpReader(reader, job.records)
+	}
+	err = scanRecords(ctx, config, reader, &processedBytes)
+	slog.Debug("chunk finished", "file", job.path, "offset", job.offset, "length", job.length)
+	if err == errDumpChecksum || err == errDumpTruncated {
+		return fmt.Errorf("dump %s at offset %d: %w", job.path, job.offset, err)
+	}
+	return err
+}
+
+// Function which splits the -write-binary dump into chunks of whole frames after the header
+// Like the chunks of the other files they're sized by the threads, -min-thread-bytes and -chunk-size
+func splitDump(config Config, file inputFile) []chunkJob {
+	body := file.size - DUMP_HEADER_SIZE
+	bytesPerChunk := max(int((body+int64(config.numThreads)-1)/int64(config.numThreads)), config.minThreadBytes)
+	if config.chunkSize > 0 {
+		bytesPerChunk = config.chunkSize
+	}
+	frames := (bytesPerChunk + DUMP_FRAME_SIZE - 1) / DUMP_FRAME_SIZE
+	bytesPerChunk = frames * DUMP_FRAME_SIZE
+
+	jobs := []chunkJob{}
+	for records := file.records; records > 0; {
+		chunkRecords := min(records, uint64(frames)*DUMP_FRAME_RECORDS)
+		offset, length := chunkRange(len(jobs), bytesPerChunk)
+		jobs = append(jobs, chunkJob{path: file.path, offset: DUMP_HEADER_SIZE + offset, length: length, dump: true, records: chunkRecords})
+		records -= chunkRecords
+	}
+	return jobs
+}
+
+// Function which reads the whole compressed -binary file as a single stream
+func readCompressedBinaryFile(ctx context.Context, config Config, path string, compression string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	compressed := countingReader{reader: file, counter: &processedBytes}
+	reader, err := decompressReader(bufio.NewReaderSize(compressed, BUFFER_SIZE), compression)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	records, _, err := streamRecords(reader)
+	if err != nil {
+		return err
+	}
+	if err = scanRecords(ctx, config, records, nil); err == errDumpChecksum || err == errDumpTruncated {
+		return fmt.Errorf("dump %s: %w", path, err)
+	}
+	return err
+}
+
+// Function which adds every 4-byte big-endian record of the reader to the set, no text is parsed
+// The records are counted as the lines, a partial record at the end of the stream can't be an
+// address, it's counted as a skipped line but not as a record and its bytes go to partialRecords
+// The bytes are added to progress unless it's nil
+// Stops early when the context is cancelled
+func scanRecords(ctx context.Context, config Config, reader io.Reader, progress *atomic.Uint64) error {
+	buffer := scanBufferPool.Get().(*[]byte)
+	defer scanBufferPool.Put(buffer)
+	buf := *buffer // BUFFER_SIZE is a multiple of the record size, so no record is split between two reads
+
+	networkShift := uint(32 - config.networkBits)
+	records := 0
+	defer func() {
+		totalLines.Add(uint64(records))
+		binaryRecords.Add(uint64(records))
+	}()
+
+	for ctx.Err() == nil {
+		n, err := io.ReadFull(reader, buf)
+		whole := n - n%BINARY_RECORD_SIZE
+		for i := 0; i < whole; i += BINARY_RECORD_SIZE {
+			addIp(binary.BigEndian.Uint32(buf[i:]), networkShift)
+		}
+		records += whole / BINARY_RECORD_SIZE
+		if progress != nil {
+			progress.Add(uint64(n))
+		}
+
+		switch err {
+		case nil:
+			continue
+		case io.EOF, io.ErrUnexpectedEOF:
+			if partial := n - whole; partial > 0 {
+				partialRecords.Add(uint64(partial))
+				totalLines.Add(1)
+				skippedLines.Add(1)
+			}
+			return nil
+		default:
+			return err
+		}
+	}
+	return nil
+}
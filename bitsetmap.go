@@ -0,0 +1,74 @@
+package main
+
+import (
+	"slices"
+	"sync"
+
+	"Lightspeed_Task/ipcount"
+)
+
+const BITSET_MAP_SHARDS = 64 // Number of independently locked shards of the bitset map
+
+// Map of lazily allocated bitsets, one per bucket (time window, subnet, ...)
+// Buckets are spread over shards, so workers creating different buckets rarely wait on the same lock
+// Once a bucket exists its bits are set with atomic operations without any locking
+// Every bucket is a sparse bitset, a full 512MB array per bucket would limit the map to a bucket or two
+type bitsetMap struct {
+	shards [BITSET_MAP_SHARDS]bitsetMapShard
+}
+
+type bitsetMapShard struct {
+	mu      sync.RWMutex
+	buckets map[uint64]*ipcount.SparseSet
+}
+
+// Function which creates an empty map of bitsets
+func newBitsetMap() *bitsetMap {
+	m := &bitsetMap{}
+	for i := range m.shards {
+		m.shards[i].buckets = map[uint64]*ipcount.SparseSet{}
+	}
+	return m
+}
+
+// Function which returns the bitset of the bucket, allocating it on the first use
+// The fast path takes only the read lock, the write lock is taken when the bucket is missing
+// and the bucket is checked again, so concurrent callers always get the same bitset
+func (m *bitsetMap) bucket(key uint64) *ipcount.SparseSet {
+	shard := &m.shards[key%BITSET_MAP_SHARDS]
+
+	shard.mu.RLock()
+	set, ok := shard.buckets[key]
+	shard.mu.RUnlock()
+	if ok {
+		return set
+	}
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if set, ok = shard.buckets[key]; !ok {
+		set = ipcount.NewSparseSet()
+		shard.buckets[key] = set
+	}
+	return set
+}
+
+// Function which sets the bit of the IP address in the bitset of the bucket
+func (m *bitsetMap) add(key uint64, ip uint32) {
+	m.bucket(key).Add(ip)
+}
+
+// Function which returns the keys of all allocated buckets in ascending order
+func (m *bitsetMap) keys() []uint64 {
+	keys := []uint64{}
+	for i := range m.shards {
+		shard := &m.shards[i]
+		shard.mu.RLock()
+		for key := range shard.buckets {
+			keys = append(keys, key)
+		}
+		shard.mu.RUnlock()
+	}
+	slices.Sort(keys)
+	return keys
+}
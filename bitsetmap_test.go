@@ -0,0 +1,82 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// A year of hourly windows with one address each must fit in tens of MB, not in gigabytes
+func TestBitsetMapManyBucketsMemory(t *testing.T) {
+	const buckets = 24 * 365
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	m := newBitsetMap()
+	for key := range uint64(buckets) {
+		m.add(key, uint32(key)<<8)
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	used := after.HeapAlloc - before.HeapAlloc
+	if limit := uint64(buckets) * 16 << 10; used > limit {
+		t.Fatalf("%d buckets use %d bytes, want at most %d", buckets, used, limit)
+	}
+
+	keys := m.keys()
+	if len(keys) != buckets {
+		t.Fatalf("%d keys, want %d", len(keys), buckets)
+	}
+	for _, key := range keys {
+		set := m.bucket(key)
+		if set.Count() != 1 || !set.Contains(uint32(key)<<8) {
+			t.Fatalf("bucket %d: count %d, want only %d", key, set.Count(), uint32(key)<<8)
+		}
+	}
+	runtime.KeepAlive(m)
+}
+
+// Many goroutines create and fill the same buckets at the same time, each bucket must end up with
+// one bitset holding the addresses of all goroutines. Run under -race: an unlocked map write or a
+// bucket allocated twice is reported or loses the bits written into the dropped copy
+func TestBitsetMapConcurrentBuckets(t *testing.T) {
+	const goroutines = 16
+	const buckets = 500
+	const perBucket = 64
+
+	m := newBitsetMap()
+	start := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			// the goroutines walk the buckets from different ends, so the first use of a bucket races
+			for i := range buckets {
+				key := uint64(i)
+				if g%2 == 1 {
+					key = uint64(buckets - 1 - i)
+				}
+				for j := range perBucket {
+					m.add(key, uint32(key)<<16|uint32(g*perBucket+j))
+				}
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	keys := m.keys()
+	if len(keys) != buckets {
+		t.Fatalf("%d buckets, want %d", len(keys), buckets)
+	}
+	for _, key := range keys {
+		if count := m.bucket(key).Count(); count != goroutines*perBucket {
+			t.Errorf("bucket %d: count = %d, want %d", key, count, goroutines*perBucket)
+		}
+	}
+}
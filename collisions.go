@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+
+	"Lightspeed_Task/ipcount"
+)
+
+const (
+	COLLISION_EXAMPLES = 10 // Collisions reported with their lines by -count-collisions, the rest are only counted
+)
+
+var collisions *collisionSampler // Parser diagnostic of -count-collisions, nil without it
+
+// Two textually different lines of the input which were parsed to the same address
+type Collision struct {
+	Ip     string `json:"ip"`     // The address both lines were parsed to
+	First  string `json:"first"`  // Line which was parsed to the address first
+	Second string `json:"second"` // Line which collided with it
+}
+
+// Collisions found in the sampled lines by -count-collisions
+type CollisionReport struct {
+	Sampled  uint64      `json:"sampled"`  // Lines with an address which were checked
+	Count    uint64      `json:"count"`    // Lines which collided with an earlier different line
+	Examples []Collision `json:"examples"` // The first COLLISION_EXAMPLES collisions
+}
+
+// Diagnostic of the parser which remembers the first line of every address of the sampled lines
+// A correct parser maps different dotted-quads to different addresses, so another line with the same
+// address must be the same text; when it isn't, a length or format filter let through a line it
+// should have rejected (like 1.2.3.04 or 256.1.1.1 with the fast parser) and the two are reported
+type collisionSampler struct {
+	mu        sync.Mutex
+	remaining atomic.Int64      // Lines still to sample, the check is skipped once it's 0
+	first     map[uint32]string // First sampled line of every address
+	report    CollisionReport
+}
+
+func newCollisionSampler(sample int) *collisionSampler {
+	c := &collisionSampler{first: map[uint32]string{}, report: CollisionReport{Examples: []Collision{}}}
+	c.remaining.Store(int64(sample))
+	return c
+}
+
+// Function which checks the line parsed to the address against the first line of the address
+// The IPv4-mapped prefix (::ffff:) is an accepted notation of the same address, so it's not compared
+func (c *collisionSampler) check(line []byte, ip uint32) {
+	if c.remaining.Load() <= 0 || c.remaining.Add(-1) < 0 {
+		return
+	}
+	line = ipcount.TrimMappedPrefix(line)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.report.Sampled++
+	first, seen := c.first[ip]
+	if !seen {
+		c.first[ip] = string(line)
+		return
+	}
+	if bytes.Equal(line, []byte(first)) {
+		return
+	}
+	c.report.Count++
+	if len(c.report.Examples) < COLLISION_EXAMPLES {
+		c.report.Examples = append(c.report.Examples, Collision{Ip: string(appendDottedIp(nil, ip)), First: first, Second: string(line)})
+	}
+}
+
+// Function which reports whether the lines are parsed as plain dotted-quads, the only input whose
+// different lines must be different addresses
+func isPlainDotted(parser LineParser) bool {
+	dotted, ok := parser.(DottedQuadParser)
+	return ok && !dotted.MultiFormat
+}
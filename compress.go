@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic  = []byte{0x1F, 0x8B}
+	bzip2Magic = []byte("BZh")
+	zstdMagic  = []byte{0x28, 0xB5, 0x2F, 0xFD}
+)
+
+// Function which detects the compression of the file by the magic bytes at its beginning
+// zstd files may start with a skippable frame instead of the magic, so the .zst extension is checked too
+// Returns "gzip", "bzip2", "zstd" or an empty string for the plain text
+func detectCompression(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	compression := compressionOf(head[:n])
+	if compression == "" && strings.HasSuffix(name, ".zst") {
+		compression = "zstd"
+	}
+	return compression, nil
+}
+
+// Function which matches the first bytes of the input against the known magic numbers
+func compressionOf(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(head, bzip2Magic):
+		return "bzip2"
+	case bytes.HasPrefix(head, zstdMagic):
+		return "zstd"
+	}
+	return ""
+}
+
+// Function which wraps the reader with the decompressor of the given compression
+// The returned reader must be closed to release the decompressor
+func decompressReader(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "bzip2":
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return io.NopCloser(r), nil
+}
+
+// Reader which adds the number of bytes read from the underlying reader to the counter
+type countingReader struct {
+	reader  io.Reader
+	counter *atomic.Uint64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.counter.Add(uint64(n))
+	return n, err
+}
+
+// Function which reads the whole compressed file as a single stream
+// The progress is counted in the compressed bytes, so it's comparable with the file size
+func readCompressedFile(ctx context.Context, config Config, path string, compression string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	compressed := countingReader{reader: file, counter: &processedBytes}
+	reader, err := decompressReader(bufio.NewReaderSize(compressed, BUFFER_SIZE), compression)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return scanLines(ctx, config, reader, 0, math.MaxInt, nil)
+}
+
+// Function which reads the lines piped to stdin into the set after the files, for -also-stdin
+// The stream can't be split, so it's read by one thread like a compressed file, in the same input format;
+// a compressed stream is recognized by the magic bytes like a file
+// The read stops when the context of the run is cancelled, and at the -max-memory budget like the files
+func readStdin(ctx context.Context, config Config) error {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		slog.Info("reading the IP addresses from the terminal, end the input with Ctrl-D")
+	}
+	stdin := bufio.NewReaderSize(countingReader{reader: os.Stdin, counter: &processedBytes}, BUFFER_SIZE)
+	head, err := stdin.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	reader, err := decompressReader(stdin, compressionOf(head))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopMemoryWatch := func() error { return nil }
+	if config.maxMemory > 0 {
+		stopMemoryWatch = watchMemory(ctx, config.maxMemory, cancel)
+	}
+	err = scanLines(ctx, config, reader, 0, math.MaxInt, nil)
+	if watchErr := stopMemoryWatch(); err == nil {
+		err = watchErr
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"Lightspeed_Task/ipcount"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Function which compresses the content with the encoder returned by newWriter
+func compress(t *testing.T, content string, newWriter func(io.Writer) (io.WriteCloser, error)) string {
+	t.Helper()
+	var buf bytes.Buffer
+	writer, err := newWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(writer, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func newZstdWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func newGzipWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+// Generated addresses compressed with zstd are counted like the plain file, whether the stream is
+// recognized by its magic bytes or, behind a skippable frame, by the .zst extension
+func TestZstdRoundTrip(t *testing.T) {
+	lines := ipLines(50000)
+	content := strings.Join(append(lines, lines[:1000]...), "\n") + "\n"
+	compressed := compress(t, content, newZstdWriter)
+
+	// a skippable frame (magic 0x184D2A50) of 4 bytes hides the zstd magic at the beginning
+	skippable := binary.LittleEndian.AppendUint32(nil, 0x184D2A50)
+	skippable = binary.LittleEndian.AppendUint32(skippable, 4)
+	skippable = append(skippable, "skip"...)
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"magic", "ips", compressed},
+		{"extension", "ips.zst", compressed},
+		{"skippable frame", "ips.zst", string(skippable) + compressed},
+		{"gzip", "ips.gz", compress(t, content, newGzipWriter)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeTestFile(t, test.file, test.content)
+			config := testConfig(path)
+			config.numThreads = 4
+			result := mustCount(t, config)
+			if result.Unique != uint64(len(lines)) || result.Skipped != 0 {
+				t.Errorf("unique = %d, skipped = %d, want %d and 0", result.Unique, result.Skipped, len(lines))
+			}
+			if lines := totalLines.Load(); lines != 51000 {
+				t.Errorf("lines = %d, want 51000", lines)
+			}
+		})
+	}
+}
+
+// A corrupted zstd stream fails the run instead of counting the addresses decoded before the damage
+func TestZstdCorrupted(t *testing.T) {
+	compressed := []byte(compress(t, strings.Join(ipLines(50000), "\n")+"\n", newZstdWriter))
+	compressed = compressed[:len(compressed)/2]
+	path := writeTestFile(t, "ips.zst", string(compressed))
+	if _, errs := runCount(t, testConfig(path)); len(errs) == 0 {
+		t.Error("the truncated zstd stream was read without an error")
+	}
+}
+
+// The stdin is read with the context of the run: a cancelled run stops the read at the first check,
+// and -max-errors reached on the files leaves the stdin unread like -fail-fast
+func TestStdinFollowsTheRun(t *testing.T) {
+	lines := ipLines(4 * CANCEL_CHECK)
+	stdinPath := writeTestFile(t, "stdin.txt", strings.Join(lines, "\n")+"\n")
+	setStdin := func() {
+		stdin, err := os.Open(stdinPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		previous := os.Stdin
+		os.Stdin = stdin
+		t.Cleanup(func() {
+			os.Stdin = previous
+			stdin.Close()
+		})
+	}
+
+	setStdin()
+	resetGlobals()
+	ips = ipcount.NewSparseSet()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := readStdin(ctx, testConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if read := totalLines.Load(); read >= uint64(len(lines)) {
+		t.Errorf("cancelled read took %d lines, want it stopped at the first check", read)
+	}
+
+	// the panic of the file is its one error, so the lines of the stdin are never parsed
+	for _, adjust := range []func(config *Config){
+		func(config *Config) { config.maxErrors = 1 },
+		func(config *Config) { config.failFast = true },
+	} {
+		setStdin()
+		config := testConfig(writeTestFile(t, "input.txt", "1.2.3.4.5\n"))
+		config.parser, config.alsoStdin = panickingParser{trigger: "1.2.3.4.5"}, true
+		adjust(&config)
+		if result, errs := runCount(t, config); len(errs) != 1 || result.Unique != 0 {
+			t.Errorf("unique = %d with errors %v, want only the panic of the file", result.Unique, errs)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"Lightspeed_Task/ipcount"
+)
+
+const (
+	DETECT_LINES    = 100 // Number of lines sampled by the IP field detection
+	DETECT_MIN_RATE = 0.9 // Share of the sampled lines in which the field must be an IP address
+
+	FORMAT_DETECT_LINES = 100 // Number of non-empty lines sampled by -format-autodetect
+)
+
+// Parser of the whitespace delimited lines (space or tab, repeated delimiters count as one)
+// which takes the IP address from the field with the given index, or from the first field
+// which is an IP address when the index is negative
+// With a Delimiter every delimiter ends a field, also an empty one like in CSV, and the blanks
+// around the field are trimmed
+type FieldParser struct {
+	Field     int              // Index of the IP field from 0, negative to use the first field which parses
+	Address   DottedQuadParser // Parser of the field
+	Delimiter byte             // Separator of the fields, 0 for whitespace
+}
+
+func (p FieldParser) Parse(line []byte) (uint32, bool) {
+	if p.Delimiter != 0 {
+		return p.parseDelimited(line)
+	}
+	field := 0
+	for start := 0; start < len(line); {
+		if line[start] == ' ' || line[start] == '\t' {
+			start++
+			continue
+		}
+		end := start
+		for end < len(line) && line[end] != ' ' && line[end] != '\t' {
+			end++
+		}
+		if p.Field < 0 || field == p.Field {
+			if ip, ok := p.Address.Parse(line[start:end]); ok || p.Field >= 0 {
+				return ip, ok
+			}
+		}
+		field++
+		start = end
+	}
+	return 0, false
+}
+
+func (p FieldParser) parseDelimited(line []byte) (uint32, bool) {
+	for field, start := 0, 0; ; field++ {
+		end := bytes.IndexByte(line[start:], p.Delimiter)
+		if end < 0 {
+			end = len(line)
+		} else {
+			end += start
+		}
+		if p.Field < 0 || field == p.Field {
+			if ip, ok := p.Address.Parse(ipcount.TrimBlanks(line[start:end])); ok || p.Field >= 0 {
+				return ip, ok
+			}
+		}
+		if end == len(line) {
+			return 0, false
+		}
+		start = end + 1
+	}
+}
+
+// Function which detects the field holding the IP address from the first lines of the file, the fields
+// are separated by the delimiter or by whitespace when it's 0
+// A field is chosen when it's a valid address in at least 90% of the sampled lines and no other field is,
+// otherwise (no such field or more of them) the parser takes the first field which is an address
+func detectIpField(path string, address DottedQuadParser, delimiter byte) (FieldParser, error) {
+	sample, err := sampleLines(path, DETECT_LINES, false)
+	if err != nil {
+		return FieldParser{}, err
+	}
+
+	// the fields are validated strictly, so numbers like 1.2 or timestamps don't look like addresses
+	strict := address
+	strict.Strict = true
+	hits := []int{}
+	lines := len(sample)
+	for _, line := range sample {
+		for field := 0; ; field++ {
+			if _, ok := (FieldParser{Field: field, Address: strict, Delimiter: delimiter}).Parse(line); ok {
+				for len(hits) <= field {
+					hits = append(hits, 0)
+				}
+				hits[field]++
+			} else if !hasField(line, field, delimiter) {
+				break
+			}
+		}
+	}
+
+	detected := -1
+	for field, count := range hits {
+		if float64(count) >= DETECT_MIN_RATE*float64(lines) {
+			if detected >= 0 {
+				slog.Info("IP field is ambiguous, the first address of every line is used", "fields", []int{detected + 1, field + 1})
+				return FieldParser{Field: -1, Address: address, Delimiter: delimiter}, nil
+			}
+			detected = field
+		}
+	}
+	if detected < 0 {
+		slog.Info("no field is an IP address in the sampled lines, the first address of every line is used", "lines", lines)
+	} else {
+		slog.Info("detected IP field", "field", detected+1, "lines", lines)
+	}
+	return FieldParser{Field: detected, Address: address, Delimiter: delimiter}, nil
+}
+
+// Function which detects the format of the whole input from the first non-empty lines of the file
+// Every sampled line is classified on its own (dotted, int, hex, jsonl or weblog) and all of them
+// must agree, only the three forms of a bare address may be mixed, they're read with -multi-format
+// A line of no known format or a mix of formats is an error which tells the user what to set instead
+func detectLineFormat(path string, jsonKey string, address DottedQuadParser) (LineParser, error) {
+	sample, err := sampleLines(path, FORMAT_DETECT_LINES, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(sample) == 0 {
+		slog.Info("no lines to detect the format from, dotted is used", "file", path)
+		return address, nil
+	}
+
+	jsonl := NewJSONLParser(jsonKey, address)
+	counts := map[string]int{}
+	for i, line := range sample {
+		format := classifyLine(bytes.TrimSpace(line), jsonl)
+		if format == "" {
+			return nil, fmt.Errorf("line %d of %s matches no known format (dotted, int, hex, jsonl with -json-key %q, weblog): %q, "+
+				"set -in-format, or use -in-format auto or -regex for other logs", i+1, path, jsonKey, line)
+		}
+		counts[format]++
+	}
+
+	numeric := counts["dotted"] + counts["int"] + counts["hex"]
+	switch {
+	case counts["dotted"] == len(sample):
+		slog.Info("detected input format", "format", "dotted", "lines", len(sample))
+		return address, nil
+	case numeric == len(sample):
+		// one address may be written in several notations, -multi-format normalizes them all
+		slog.Info("detected input format", "format", "int/hex (-multi-format)", "lines", len(sample), "formats", counts)
+		address.MultiFormat = true
+		return address, nil
+	case counts["jsonl"] == len(sample):
+		slog.Info("detected input format", "format", "jsonl", "key", jsonKey, "lines", len(sample))
+		return jsonl, nil
+	case counts["weblog"] == len(sample):
+		slog.Info("detected input format", "format", "weblog", "lines", len(sample))
+		return WebLogParser{address}, nil
+	}
+	return nil, fmt.Errorf("the first %d lines of %s mix formats %v, set -in-format for the intended one "+
+		"(lines of other formats are then skipped) or split the file", len(sample), path, counts)
+}
+
+// Function which returns the format of the trimmed line, empty when it's none of the known ones
+// The addresses are validated strictly, so a number or a word isn't mistaken for one
+func classifyLine(line []byte, jsonl JSONLParser) string {
+	strict := DottedQuadParser{Strict: true}
+	if len(line) > 0 && line[0] == '{' {
+		if _, ok := jsonl.Parse(line); ok {
+			return "jsonl"
+		}
+		return ""
+	}
+	if _, ok := strict.Parse(line); ok {
+		return "dotted"
+	}
+	if _, ok, isInt := parseIntIp(line); isInt {
+		if !ok {
+			return ""
+		}
+		if len(line) > 2 && (line[1] == 'x' || line[1] == 'X') {
+			return "hex"
+		}
+		return "int"
+	}
+	if _, ok := (WebLogParser{strict}).Parse(line); ok && bytes.IndexByte(line, '[') > 0 {
+		return "weblog"
+	}
+	return ""
+}
+
+// Function which returns up to limit first lines of the file (decompressed), skipBlank leaves out
+// the lines which are empty or only whitespace
+func sampleLines(path string, limit int, skipBlank bool) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	compression, err := detectCompression(path)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := decompressReader(bufio.NewReaderSize(file, BUFFER_SIZE), compression)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), BUFFER_SIZE)
+	splitter := lineSplitter{}
+	scanner.Split(splitter.split)
+
+	lines := [][]byte{}
+	for len(lines) < limit && scanner.Scan() {
+		if skipBlank && len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		lines = append(lines, bytes.Clone(scanner.Bytes()))
+	}
+	return lines, scanner.Err()
+}
+
+// Function which reports whether the line has a field with the index, delimited like FieldParser
+func hasField(line []byte, field int, delimiter byte) bool {
+	if delimiter != 0 {
+		return field <= bytes.Count(line, []byte{delimiter})
+	}
+	count := 0
+	inField := false
+	for _, b := range line {
+		if b == ' ' || b == '\t' {
+			inField = false
+		} else if !inField {
+			inField = true
+			count++
+		}
+	}
+	return field < count
+}
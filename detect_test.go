@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// The fields are parsed strictly, the default parser would take any 7 to 15 bytes with dots
+func TestDelimitedFields(t *testing.T) {
+	tests := []struct {
+		delimiter byte
+		field     int
+		line      string
+		ip        uint32
+		ok        bool
+	}{
+		{',', 1, "2024-01-01,10.0.0.1,GET", 0x0A000001, true},
+		{',', 1, "2024-01-01, 10.0.0.1 ,GET", 0x0A000001, true}, // blanks around the field are trimmed
+		{',', 2, "a,,10.0.0.1", 0x0A000001, true},               // the empty field still counts
+		{',', 1, "a,,10.0.0.1", 0, false},
+		{',', 0, "10.0.0.1", 0x0A000001, true},
+		{',', 2, "10.0.0.1,x", 0, false},
+		{',', -1, "x,y,10.0.0.2,10.0.0.3", 0x0A000002, true},
+		{',', -1, "x,y", 0, false},
+		{',', 0, "10.0.0.1 x,y", 0, false}, // a space doesn't separate the fields
+		{'|', 1, "GET|192.168.0.1|200", 0xC0A80001, true},
+		{'|', 2, "GET|||192.168.0.1", 0, false},
+		{'|', 3, "GET|||192.168.0.1", 0xC0A80001, true},
+		{'|', -1, "GET|200|192.168.0.1", 0xC0A80001, true},
+		{'|', 0, "10.0.0.1,x|y", 0, false},
+		{'\t', 1, "a b\t10.0.0.1", 0x0A000001, true},
+	}
+	for _, test := range tests {
+		parser := FieldParser{Field: test.field, Address: DottedQuadParser{Strict: true}, Delimiter: test.delimiter}
+		ip, ok := parser.Parse([]byte(test.line))
+		if ok != test.ok || (ok && ip != test.ip) {
+			t.Errorf("%+v.Parse(%q) = %08x, %v, want %08x, %v", parser, test.line, ip, ok, test.ip, test.ok)
+		}
+	}
+}
+
+// Comma- and pipe-delimited logs are counted from the detected field, also when the field before it
+// holds an address in a few lines only
+func TestDelimitedInputs(t *testing.T) {
+	for _, delimiter := range []byte{',', '|'} {
+		sep := string(delimiter)
+		lines := []string{"time" + sep + "client" + sep + "status"}
+		for i, ip := range ipLines(200) {
+			source := "host" + sep
+			if i%50 == 0 {
+				source = "172.16.0.1" + sep
+			}
+			lines = append(lines, "2024-01-01T00:00:00"+sep+source+ip+sep+"200")
+		}
+		lines = append(lines, "2024-01-01T00:00:00"+sep+"host"+sep+"10.0.0.1 "+sep+"200") // a duplicate
+		path := writeTestFile(t, "input.log", strings.Join(lines, "\n")+"\n")
+
+		parser, err := detectIpField(path, DottedQuadParser{}, delimiter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parser.Field != 2 || parser.Delimiter != delimiter {
+			t.Fatalf("%q: detected %+v, want field 2", sep, parser)
+		}
+		config := testConfig(path)
+		config.parser, config.delimiter = parser, delimiter
+		result := mustCount(t, config)
+		if result.Unique != 200 || result.Skipped != 1 {
+			t.Errorf("%q: unique = %d, skipped = %d, want 200 and the header", sep, result.Unique, result.Skipped)
+		}
+	}
+}
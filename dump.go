@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+const (
+	DUMP_MAGIC         = "\xffIPDUMP1" // First bytes of a -write-binary dump, not a plausible start of raw records
+	DUMP_HEADER_SIZE   = 16            // Magic and the record count as a big-endian uint64
+	DUMP_FRAME_RECORDS = 65536         // Records of one checksummed frame, 256KB
+	DUMP_CHECKSUM_SIZE = 4             // CRC32 of the records of a frame, after them
+	DUMP_FRAME_SIZE    = DUMP_FRAME_RECORDS*BINARY_RECORD_SIZE + DUMP_CHECKSUM_SIZE
+)
+
+var dumpTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errDumpChecksum = errors.New("checksum mismatch, the dump is corrupted")
+var errDumpTruncated = errors.New("the dump ends before its last record, it's truncated")
+
+// Function which returns the size of the dump of the given number of records with its header and checksums
+// Every frame holds DUMP_FRAME_RECORDS records except the last one, which holds the rest
+func dumpSize(records uint64) int64 {
+	frames := (records + DUMP_FRAME_RECORDS - 1) / DUMP_FRAME_RECORDS
+	return DUMP_HEADER_SIZE + int64(records)*BINARY_RECORD_SIZE + int64(frames)*DUMP_CHECKSUM_SIZE
+}
+
+// Function which returns the record count of the dump header, false when the bytes aren't a dump header
+func parseDumpHeader(header []byte) (uint64, bool) {
+	if len(header) < DUMP_HEADER_SIZE || !bytes.HasPrefix(header, []byte(DUMP_MAGIC)) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(header[len(DUMP_MAGIC):]), true
+}
+
+// Function which reads the header of the plain file, the record count is valid only when it's a dump
+// The size of a dump must match its record count, so a truncated or extended dump fails before it's read
+func readDumpHeader(name string, size int64) (uint64, bool, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+
+	header := make([]byte, DUMP_HEADER_SIZE)
+	if _, err := io.ReadFull(file, header); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	records, ok := parseDumpHeader(header)
+	if ok && dumpSize(records) != size {
+		return 0, false, fmt.Errorf("dump %s of %d records must be %d bytes, not %d, it's truncated or corrupted", name, records, dumpSize(records), size)
+	}
+	return records, ok, nil
+}
+
+// Writer of the records of a -write-binary dump which writes the CRC32 of every DUMP_FRAME_RECORDS records after them
+// The records may be written in pieces of any size, Close writes the checksum of the last partial frame
+type dumpWriter struct {
+	writer  io.Writer
+	crc     hash.Hash32
+	records uint64 // Records still expected by the header
+	inFrame int    // Bytes of the current frame written so far
+}
+
+// Function which writes the header of the dump of the given number of records and returns the writer of the records
+func newDumpWriter(writer io.Writer, records uint64) (*dumpWriter, error) {
+	header := binary.BigEndian.AppendUint64([]byte(DUMP_MAGIC), records)
+	if _, err := writer.Write(header); err != nil {
+		return nil, err
+	}
+	return &dumpWriter{writer: writer, crc: crc32.New(dumpTable), records: records}, nil
+}
+
+func (w *dumpWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), DUMP_FRAME_RECORDS*BINARY_RECORD_SIZE-w.inFrame)
+		if uint64(w.inFrame+n)/BINARY_RECORD_SIZE > w.records {
+			return written, fmt.Errorf("more records than the %d of the dump header", w.records)
+		}
+		if _, err := w.writer.Write(p[:n]); err != nil {
+			return written, err
+		}
+		w.crc.Write(p[:n])
+		w.inFrame += n
+		written += n
+		p = p[n:]
+		if w.inFrame == DUMP_FRAME_RECORDS*BINARY_RECORD_SIZE {
+			if err := w.endFrame(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Function which writes the checksum of the current frame and starts the next one
+func (w *dumpWriter) endFrame() error {
+	if _, err := w.writer.Write(w.crc.Sum(nil)); err != nil {
+		return err
+	}
+	w.records -= uint64(w.inFrame / BINARY_RECORD_SIZE)
+	w.crc.Reset()
+	w.inFrame = 0
+	return nil
+}
+
+// Function which ends the last frame, the records written must match the count of the header
+func (w *dumpWriter) Close() error {
+	if w.inFrame > 0 {
+		if err := w.endFrame(); err != nil {
+			return err
+		}
+	}
+	if w.records != 0 {
+		return fmt.Errorf("%d records of the dump header were not written", w.records)
+	}
+	return nil
+}
+
+// Reader of the records of a -write-binary dump without the header, which checks the checksum of every frame
+// It returns the records only, so they can be scanned like a plain -binary file, and fails with
+// errDumpChecksum on a corrupted frame and with errDumpTruncated when the dump ends too early
+// The error is returned by every later read too, io.ReadFull drops it when the buffer is already full
+type dumpReader struct {
+	reader  io.Reader
+	crc     hash.Hash32
+	records uint64 // Records still to be read after the current frame
+	left    int    // Bytes of the records of the current frame still to be read
+	err     error
+}
+
+// Function which returns the reader of the given number of records, reader is positioned on the first frame
+func newDumpReader(reader io.Reader, records uint64) *dumpReader {
+	r := &dumpReader{reader: reader, crc: crc32.New(dumpTable), records: records}
+	r.startFrame()
+	return r
+}
+
+// Function which sets the size of the next frame, the last one holds the remaining records
+func (r *dumpReader) startFrame() {
+	r.left = int(min(r.records, DUMP_FRAME_RECORDS)) * BINARY_RECORD_SIZE
+	r.records -= uint64(r.left / BINARY_RECORD_SIZE)
+}
+
+func (r *dumpReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if r.left == 0 {
+		return 0, io.EOF
+	}
+	n, err := r.reader.Read(p[:min(len(p), r.left)])
+	r.crc.Write(p[:n])
+	r.left -= n
+	switch {
+	case r.left == 0:
+		r.err = r.checkFrame()
+	case err == io.EOF:
+		r.err = errDumpTruncated
+	default:
+		r.err = err
+	}
+	return n, r.err
+}
+
+// Function which compares the checksum after the frame with the one of its records and starts the next frame
+func (r *dumpReader) checkFrame() error {
+	checksum := make([]byte, DUMP_CHECKSUM_SIZE)
+	if _, err := io.ReadFull(r.reader, checksum); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errDumpTruncated
+	} else if err != nil {
+		return err
+	}
+	if !bytes.Equal(checksum, r.crc.Sum(nil)) {
+		return errDumpChecksum
+	}
+	r.crc.Reset()
+	r.startFrame()
+	return nil
+}
+
+// Function which returns the reader of the records of the stream and whether the stream is a dump
+// The header of a dump is consumed and its frames are checked, any other stream is returned as it is
+// and read as raw records
+func streamRecords(reader io.Reader) (io.Reader, bool, error) {
+	buffered := bufio.NewReaderSize(reader, BUFFER_SIZE)
+	header, err := buffered.Peek(DUMP_HEADER_SIZE)
+	if err != nil && err != io.EOF {
+		return nil, false, err
+	}
+	records, ok := parseDumpHeader(header)
+	if !ok {
+		return buffered, false, nil
+	}
+	buffered.Discard(DUMP_HEADER_SIZE)
+	return io.MultiReader(newDumpReader(buffered, records), trailingReader{buffered}), true, nil
+}
+
+// Reader which fails when the stream has any byte after the last frame of the dump
+type trailingReader struct {
+	reader io.Reader
+}
+
+func (r trailingReader) Read(p []byte) (int, error) {
+	var b [1]byte
+	if n, _ := r.reader.Read(b[:]); n > 0 {
+		return 0, errors.New("data after the last record of the dump, it's corrupted")
+	}
+	return 0, io.EOF
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"Lightspeed_Task/ipcount"
+)
+
+// Function which writes the -write-binary-checksum dump of the addresses with the given number of threads
+func writeTestDump(t *testing.T, ips []uint32, threads int) string {
+	t.Helper()
+	set := Set(ipcount.NewSparseSet())
+	if threads > 1 {
+		set = NewIPSet(bitsetWords(32))
+	}
+	for _, ip := range ips {
+		set.Add(ip)
+	}
+	path := filepath.Join(t.TempDir(), "dump.bin")
+	if err := writeBinaryIps(path, set, 32, threads, true); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Function which counts the unique addresses of the -binary file
+func binaryCount(t *testing.T, path string, threads int) (Result, []error) {
+	t.Helper()
+	config := testConfig(path)
+	config.binary = true
+	config.numThreads = threads
+	config.minThreadBytes = 1
+	return runCount(t, config)
+}
+
+// Function which overwrites the byte of the file at the offset with its complement
+func flipByte(t *testing.T, path string, offset int64) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] = ^b[0]
+	if _, err := file.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The dump of 2 full frames and a partial one is read back whole by -binary, by one and by several
+// chunks of whole frames, and by -baseline
+func TestDumpRoundTrip(t *testing.T) {
+	ips := randomIps(2*DUMP_FRAME_RECORDS+17, 1)
+	unique := map[uint32]bool{}
+	for _, ip := range ips {
+		unique[ip] = true
+	}
+
+	for _, writers := range []int{1, 4} {
+		path := writeTestDump(t, ips, writers)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != dumpSize(uint64(len(unique))) {
+			t.Fatalf("dump of %d records is %d bytes, want %d", len(unique), info.Size(), dumpSize(uint64(len(unique))))
+		}
+
+		for _, threads := range []int{1, 2, 4} {
+			result, errs := binaryCount(t, path, threads)
+			if len(errs) > 0 || result.Unique != uint64(len(unique)) || result.Skipped != 0 {
+				t.Errorf("%d writers, -t %d: unique = %d, skipped = %d, errors %v, want %d", writers, threads, result.Unique, result.Skipped, errs, len(unique))
+			}
+		}
+
+		set, err := readBaseline(path, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if set.Count() != uint64(len(unique)) {
+			t.Errorf("%d writers: baseline of %d addresses, want %d", writers, set.Count(), len(unique))
+		}
+	}
+}
+
+func TestDumpEmpty(t *testing.T) {
+	path := writeTestDump(t, nil, 1)
+	if info, err := os.Stat(path); err != nil || info.Size() != DUMP_HEADER_SIZE {
+		t.Fatalf("empty dump: %v, %v, want %d bytes", info, err, DUMP_HEADER_SIZE)
+	}
+	if result, errs := binaryCount(t, path, 2); len(errs) > 0 || result.Unique != 0 {
+		t.Errorf("unique = %d, errors %v, want 0", result.Unique, errs)
+	}
+	if set, err := readBaseline(path, 0); err != nil || set.Count() != 0 {
+		t.Errorf("baseline: %v, want an empty set", err)
+	}
+}
+
+// A flipped byte in the records or the checksums of any frame fails both readers of the dump
+func TestDumpFlippedByte(t *testing.T) {
+	ips := randomIps(2*DUMP_FRAME_RECORDS+17, 2)
+	frame := int64(DUMP_FRAME_SIZE)
+	offsets := map[string]int64{
+		"first record":            DUMP_HEADER_SIZE,
+		"record of the 2nd frame": DUMP_HEADER_SIZE + frame + 1000,
+		"checksum of a frame":     DUMP_HEADER_SIZE + frame - 1,
+		"last record":             -DUMP_CHECKSUM_SIZE - 1,
+		"last checksum":           -1,
+	}
+	for name, offset := range offsets {
+		t.Run(name, func(t *testing.T) {
+			path := writeTestDump(t, ips, 1)
+			if offset < 0 {
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				offset += info.Size()
+			}
+			flipByte(t, path, offset)
+
+			for _, threads := range []int{1, 4} {
+				_, errs := binaryCount(t, path, threads)
+				if len(errs) != 1 || !errors.Is(errs[0], errDumpChecksum) {
+					t.Errorf("-binary -t %d: errors %v, want a checksum mismatch", threads, errs)
+				}
+			}
+			if _, err := readBaseline(path, 0); !errors.Is(err, errDumpChecksum) {
+				t.Errorf("baseline: error %v, want a checksum mismatch", err)
+			}
+		})
+	}
+}
+
+// A dump cut short or with a wrong record count in its header is rejected before its records are read
+func TestDumpTruncated(t *testing.T) {
+	path := writeTestDump(t, randomIps(DUMP_FRAME_RECORDS+5, 3), 1)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cut := writeTestFile(t, "cut.bin", string(content[:len(content)-8]))
+	if _, errs := binaryCount(t, cut, 1); len(errs) != 1 || !strings.Contains(errs[0].Error(), "truncated") {
+		t.Errorf("-binary: errors %v, want a truncated dump", errs)
+	}
+	if _, err := readBaseline(cut, 0); !errors.Is(err, errDumpTruncated) {
+		t.Errorf("baseline: error %v, want a truncated dump", err)
+	}
+
+	flipByte(t, path, DUMP_HEADER_SIZE-1)
+	if _, errs := binaryCount(t, path, 1); len(errs) != 1 || !strings.Contains(errs[0].Error(), "truncated or corrupted") {
+		t.Errorf("-binary with a wrong count: errors %v, want a size mismatch", errs)
+	}
+}
+
+// Raw records without the header, the default of -write-binary, are counted by -binary and taken as
+// a baseline, a baseline which ends with a partial record is rejected
+func TestRawRecordsWithoutHeader(t *testing.T) {
+	path := writeTestFile(t, "raw.bin", "\x01\x02\x03\x04\x0a\x00\x00\x01\x01\x02\x03\x04")
+	if result, errs := binaryCount(t, path, 1); len(errs) > 0 || result.Unique != 2 {
+		t.Errorf("unique = %d, errors %v, want 2", result.Unique, errs)
+	}
+	if base, err := readBaseline(path, 0); err != nil || base.Count() != 2 || !base.Contains(0x0A000001) {
+		t.Errorf("baseline: error %v, want 1.2.3.4 and 10.0.0.1", err)
+	}
+
+	partial := writeTestFile(t, "partial.bin", "\x01\x02\x03\x04\x0a\x00")
+	if _, err := readBaseline(partial, 0); err == nil || !strings.Contains(err.Error(), "partial record") {
+		t.Errorf("baseline: error %v, want the partial record", err)
+	}
+}
+
+// The reader of a dump stream rejects the bytes after the last frame
+func TestDumpTrailingData(t *testing.T) {
+	var buf bytes.Buffer
+	dump, err := newDumpWriter(&buf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dump.Write(make([]byte, 3*BINARY_RECORD_SIZE)); err != nil {
+		t.Fatal(err)
+	}
+	if err := dump.Close(); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("junk")
+
+	reader, ok, err := streamRecords(&buf)
+	if err != nil || !ok {
+		t.Fatalf("streamRecords: %v, %v", ok, err)
+	}
+	records := make([]byte, 64)
+	n, err := reader.Read(records)
+	for err == nil {
+		var m int
+		m, err = reader.Read(records[n:])
+		n += m
+	}
+	if n != 3*BINARY_RECORD_SIZE || err == nil || err.Error() != "data after the last record of the dump, it's corrupted" {
+		t.Errorf("read %d bytes, error %v, want 12 bytes and the trailing data", n, err)
+	}
+}
+
+// count → -write-binary → -binary gives the same count, also for the networks of -network-bits
+// and with the array backend, whose dump is formatted by several threads; the file holds only the
+// records unless -write-binary-checksum is set
+func TestWriteBinaryRoundTrip(t *testing.T) {
+	lines := ipLines(70000)
+	path := writeTestFile(t, "input.txt", strings.Join(append(lines, lines[:500]...), "\n")+"\n")
+	for _, test := range []struct {
+		backend     string
+		networkBits int
+		threads     int
+		checksum    bool
+	}{{"sparse", 32, 1, false}, {"sparse", 24, 1, false}, {"array", 32, 4, false}, {"sparse", 32, 1, true}, {"array", 32, 4, true}} {
+		config := testConfig(path)
+		config.backend, config.networkBits, config.numThreads = test.backend, test.networkBits, test.threads
+		counted := mustCount(t, config)
+		// written by main after the count, like -write-binary does
+		dump := filepath.Join(t.TempDir(), "unique.bin")
+		if err := writeBinaryIps(dump, ips, test.networkBits, test.threads, test.checksum); err != nil {
+			t.Fatal(err)
+		}
+		size := int64(counted.Unique) * BINARY_RECORD_SIZE
+		if test.checksum {
+			size = dumpSize(counted.Unique)
+		}
+		if info, err := os.Stat(dump); err != nil || info.Size() != size {
+			t.Fatalf("%+v: file of %d records is %v, want %d bytes", test, counted.Unique, info, size)
+		}
+
+		reread := testConfig(dump)
+		reread.binary, reread.networkBits, reread.numThreads = true, test.networkBits, test.threads
+		result := mustCount(t, reread)
+		if result.Unique != counted.Unique || *result.Records != counted.Unique || result.Skipped != 0 {
+			t.Errorf("%+v: -binary count = %d of %d records, skipped = %d, want %d", test, result.Unique, *result.Records, result.Skipped, counted.Unique)
+		}
+	}
+}
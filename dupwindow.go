@@ -0,0 +1,44 @@
+package main
+
+// Sliding window over the last IP addresses which detects the addresses repeated within it
+// The ring keeps the order of the window and counts keeps the occurrences inside of it,
+// so every address is checked in O(1) regardless of the window size
+// The lines must be processed in the file order, so it's only used with a single thread
+type dupWindow struct {
+	ring   []uint32          // Last addresses, the oldest one is overwritten by the next address
+	next   int               // Index of the oldest address in the ring
+	filled bool              // The ring is full, so adding an address evicts the oldest one
+	counts map[uint32]uint32 // Occurrences of every address inside the window
+	events uint64            // Number of addresses which were already present in the window
+}
+
+func newDupWindow(size int) *dupWindow {
+	return &dupWindow{
+		ring:   make([]uint32, size),
+		counts: make(map[uint32]uint32),
+	}
+}
+
+// Function which adds the address to the window and counts an event when it's already in the window
+func (w *dupWindow) add(ip uint32) {
+	if w.counts[ip] > 0 {
+		w.events++
+	}
+
+	if w.filled {
+		oldest := w.ring[w.next]
+		if w.counts[oldest] == 1 {
+			delete(w.counts, oldest)
+		} else {
+			w.counts[oldest]--
+		}
+	}
+	w.ring[w.next] = ip
+	w.counts[ip]++
+
+	w.next++
+	if w.next == len(w.ring) {
+		w.next = 0
+		w.filled = true
+	}
+}
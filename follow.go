@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const FOLLOW_POLL = 250 * time.Millisecond // How often the followed file is checked for new data and rotation
+
+// Function which reads the file like tail -f: after reaching EOF it waits for the appended lines,
+// until the context is cancelled (Ctrl+C)
+// The unique count is printed every interval when it changed, while reading and while waiting
+// When the file is replaced (log rotation) or truncated, it's reopened and read from the beginning
+func followFile(ctx context.Context, config Config) error {
+	file, err := os.Open(config.filePaths[0])
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(file, BUFFER_SIZE)
+	networkShift := uint(32 - config.networkBits)
+	pending := []byte{} // Beginning of the line which is not terminated yet
+	dropping := false   // The pending line is longer than -max-line-scan-bytes and is skipped
+	offset := int64(0)  // Bytes consumed from the current file
+
+	ticker := time.NewTicker(config.followInterval)
+	defer ticker.Stop()
+	poll := time.NewTicker(FOLLOW_POLL)
+	defer poll.Stop()
+
+	printed := ^uint64(0) // Count printed last, none yet
+	report := func() {
+		count := ips.Count()
+		// an unchanged count isn't printed again, the count of the rolling window changes with the time alone
+		if count == printed && rolling == nil {
+			return
+		}
+		printed = count
+		fmt.Println("Unique ip count =", count)
+		if rolling != nil {
+			fmt.Printf("Unique ips in the last %s = %d (approximate)\n", config.countWindow, rolling.count(time.Now()))
+		}
+	}
+
+	reads := 0
+	for {
+		// a file which grows faster than it's read may never reach EOF, so the interval
+		// and Ctrl+C are also checked between the lines
+		if reads++; reads%CANCEL_CHECK == 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				report()
+			default:
+			}
+		}
+
+		line, err := reader.ReadSlice('\n')
+		offset += int64(len(line))
+
+		switch {
+		case err == nil && dropping:
+			dropping = false
+			totalLines.Add(1)
+			skippedLines.Add(1)
+			continue
+		case err == nil:
+			if len(pending) > 0 {
+				line = append(pending, line...)
+				pending = pending[:0]
+			}
+			if len(bytes.TrimRight(line, "\r\n")) > config.maxLineBytes {
+				totalLines.Add(1)
+				skippedLines.Add(1)
+				continue
+			}
+			followLine(config, line, networkShift)
+			continue
+		case err == bufio.ErrBufferFull || err == io.EOF:
+			if !dropping && len(pending)+len(line) > config.maxLineBytes {
+				dropping = true
+				pending = pending[:0]
+			}
+			if !dropping {
+				pending = append(pending, line...)
+			}
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+		default:
+			return err
+		}
+
+		// at EOF, wait for more data while reporting the count
+		select {
+		case <-ctx.Done():
+			if len(pending) > 0 {
+				followLine(config, pending, networkShift)
+			}
+			return nil
+		case <-ticker.C:
+			report()
+		case <-poll.C:
+			current, err := os.Stat(config.filePaths[0])
+			if err != nil {
+				// rotated file may not be recreated yet
+				continue
+			}
+			if os.SameFile(info, current) && current.Size() >= offset {
+				continue
+			}
+
+			// rotated or truncated, the unterminated rest of the old file is dropped
+			file.Close()
+			if file, err = os.Open(config.filePaths[0]); err != nil {
+				return err
+			}
+			if info, err = file.Stat(); err != nil {
+				return err
+			}
+			reader.Reset(file)
+			pending = pending[:0]
+			dropping = false
+			offset = 0
+		}
+	}
+}
+
+// Function which processes the line read in the follow mode and updates the line counters
+func followLine(config Config, line []byte, networkShift uint) {
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte{'\n'}), []byte{'\r'})
+	totalLines.Add(1)
+	if !processLine(config.parser, line, networkShift) {
+		skippedLines.Add(1)
+	}
+}
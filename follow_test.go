@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"Lightspeed_Task/ipcount"
+)
+
+// The count is printed every interval also while the lines are read, not only after EOF,
+// and the count which didn't change since it was printed isn't printed again
+func TestFollowPrintsWhileReading(t *testing.T) {
+	lines := ipLines(300000)
+	path := writeTestFile(t, "input.txt", strings.Join(lines, "\n")+"\n")
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = write
+	defer func() { os.Stdout = stdout }()
+
+	resetGlobals()
+	ips = ipcount.NewSparseSet()
+	config := testConfig(path)
+	config.followInterval = time.Millisecond
+	// the timeout ends the following when the whole count is never printed
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- followFile(ctx, config)
+		write.Close()
+	}()
+
+	counts := []uint64{}
+	scanner := bufio.NewScanner(read)
+	for scanner.Scan() {
+		count, err := strconv.ParseUint(strings.TrimPrefix(scanner.Text(), "Unique ip count = "), 10, 64)
+		if err != nil {
+			t.Fatalf("unexpected output %q", scanner.Text())
+		}
+		counts = append(counts, count)
+		if count == uint64(len(lines)) {
+			// a few more intervals at EOF, which must not repeat the count
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) < 2 || counts[len(counts)-1] != uint64(len(lines)) {
+		t.Fatalf("counts %v, want the partial ones and then %d", counts, len(lines))
+	}
+	for i := 1; i < len(counts); i++ {
+		if counts[i] <= counts[i-1] {
+			t.Fatalf("count %d printed after %d", counts[i], counts[i-1])
+		}
+	}
+}
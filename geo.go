@@ -0,0 +1,94 @@
+package main
+
+import (
+	"cmp"
+	"log/slog"
+	"slices"
+)
+
+var geoDb *mmdbReader // Country database of -geo-db, nil without it
+
+// Unique IPs of one country
+type CountryCount struct {
+	Country string `json:"country"` // ISO 3166 code of the country, e.g. DE
+	Count   uint64 `json:"count"`
+}
+
+// Unique IPs grouped by the countries of the -geo-db database
+type GeoReport struct {
+	Countries []CountryCount `json:"countries"` // Countries with at least one address, the most addresses first
+	Unmatched uint64         `json:"unmatched"` // Addresses the database has no country for
+}
+
+// Function which looks up the country of every address of the set in a GeoLite2-Country layout database
+// The country is the one of the location (country), or the one the network is registered in (registered_country)
+// for the networks without a location, like anycast ones
+// The addresses of a network found by the previous walk of the tree skip the walk, see mmdbCache
+// With -network-bits every network is looked up by its first address like in classCounts
+func countryCounts(set Set, networkBits int, db *mmdbReader) GeoReport {
+	report := GeoReport{}
+	countries := map[string]*CountryCount{}
+	records := map[int]*CountryCount{} // Record offset to its country, nil for the records without a country
+	var failed error
+	invalid := uint64(0)
+
+	cache := mmdbCache{db: db}
+	shift := uint(32 - networkBits)
+	set.ForEach(func(network uint32) {
+		offset, ok, err := cache.lookup(network << shift)
+		if err == nil && ok {
+			country, seen := records[offset]
+			if !seen {
+				if country, err = countryRecord(db, offset, countries); err == nil {
+					records[offset] = country
+				}
+			}
+			if country != nil {
+				country.Count++
+				return
+			}
+		}
+		if err != nil {
+			failed = err
+			invalid++
+		}
+		report.Unmatched++
+	})
+	if failed != nil {
+		slog.Warn("geo database lookups failed, the addresses are counted as unmatched", "addresses", invalid, "err", failed)
+	}
+	slog.Debug("geo database lookups", "addresses", set.Count(), "tree_walks", cache.walks)
+
+	for _, country := range countries {
+		report.Countries = append(report.Countries, *country)
+	}
+	slices.SortFunc(report.Countries, func(a, b CountryCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Country, b.Country))
+	})
+	return report
+}
+
+// Function which decodes the country code of the record, the country is shared by all the records
+// with the same code, nil when the record has no country
+func countryRecord(db *mmdbReader, offset int, countries map[string]*CountryCount) (*CountryCount, error) {
+	value, _, err := db.data.decode(offset)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]any)
+	code := ""
+	for _, key := range []string{"country", "registered_country"} {
+		if location, ok := record[key].(map[string]any); ok && code == "" {
+			code, _ = location["iso_code"].(string)
+		}
+	}
+	if code == "" {
+		return nil, nil
+	}
+	country, ok := countries[code]
+	if !ok {
+		country = &CountryCount{Country: code}
+		countries[code] = country
+	}
+	return country, nil
+}
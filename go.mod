@@ -1,3 +1,5 @@
 module Lightspeed_Task
 
 go 1.23.4
+
+require github.com/klauspost/compress v1.18.0
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+)
+
+const HLL_PRECISION = 14 // 2^14 registers, ~0.8% standard error
+
+// HyperLogLog sketch for approximate counting of distinct IP addresses in constant memory
+// Registers are updated with atomic compare-and-swap, so one sketch can be shared by all workers
+type hyperLogLog struct {
+	registers []uint32
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint32, 1<<HLL_PRECISION)}
+}
+
+// Function which mixes the bits of the IP address (splitmix64 finalizer)
+// so the register index and the rank get uniformly distributed bits
+func hashIp(ip uint32) uint64 {
+	h := uint64(ip) + 0x9e3779b97f4a7c15
+	h = (h ^ h>>30) * 0xbf58476d1ce4e5b9
+	h = (h ^ h>>27) * 0x94d049bb133111eb
+	return h ^ h>>31
+}
+
+// Function which adds the IP address to the sketch
+// The first bits of the hash select the register, which keeps the longest run of leading zeros seen
+func (h *hyperLogLog) add(ip uint32) {
+	hash := hashIp(ip)
+	register := &h.registers[hash>>(64-HLL_PRECISION)]
+	rank := uint32(bits.LeadingZeros64(hash<<HLL_PRECISION|1<<(HLL_PRECISION-1))) + 1
+
+	for {
+		current := atomic.LoadUint32(register)
+		if rank <= current || atomic.CompareAndSwapUint32(register, current, rank) {
+			return
+		}
+	}
+}
+
+// Function which estimates the number of distinct IP addresses added to the sketch
+// Small cardinalities use linear counting of the empty registers which is more precise there
+func (h *hyperLogLog) count() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for i := range h.registers {
+		rank := atomic.LoadUint32(&h.registers[i])
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
@@ -0,0 +1,276 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	IOURING_DEPTH      = 4           // Reads kept in flight by one reader
+	IOURING_BLOCK_SIZE = 1024 * 1024 // Bytes requested by one read
+
+	sysIoUringSetup = 425 // Same number on all architectures
+	sysIoUringEnter = 426
+
+	ioringOffSqRing      = 0
+	ioringOffCqRing      = 0x8000000
+	ioringOffSqes        = 0x10000000
+	ioringOpRead         = 22
+	ioringEnterGetEvents = 1
+	ioUringSqeSize       = 64
+	ioUringCqeSize       = 16
+)
+
+// Parameters of io_uring_setup, the layout of struct io_uring_params
+type ioUringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCpu  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        struct {
+		head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+		userAddr                                                        uint64
+	}
+	cqOff struct {
+		head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+		userAddr                                                        uint64
+	}
+}
+
+// Submission and completion rings of one io_uring instance mapped into the process
+type ioUring struct {
+	fd      int
+	sqRing  []byte
+	cqRing  []byte
+	sqes    []byte
+	params  ioUringParams
+	sqTail  *uint32
+	sqMask  uint32
+	sqArray unsafe.Pointer
+	cqHead  *uint32
+	cqTail  *uint32
+	cqMask  uint32
+}
+
+// Function which creates an io_uring instance with the given number of entries
+func newIoUring(entries uint32) (*ioUring, error) {
+	ring := &ioUring{}
+	fd, _, errno := syscall.Syscall(sysIoUringSetup, uintptr(entries), uintptr(unsafe.Pointer(&ring.params)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	ring.fd = int(fd)
+
+	p := &ring.params
+	var err error
+	ring.sqRing, err = syscall.Mmap(ring.fd, ioringOffSqRing, int(p.sqOff.array+p.sqEntries*4), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	if err == nil {
+		ring.cqRing, err = syscall.Mmap(ring.fd, ioringOffCqRing, int(p.cqOff.cqes+p.cqEntries*ioUringCqeSize), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	}
+	if err == nil {
+		ring.sqes, err = syscall.Mmap(ring.fd, ioringOffSqes, int(p.sqEntries*ioUringSqeSize), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	}
+	if err != nil {
+		ring.Close()
+		return nil, fmt.Errorf("io_uring mmap: %w", err)
+	}
+
+	ring.sqTail = (*uint32)(unsafe.Pointer(&ring.sqRing[p.sqOff.tail]))
+	ring.sqMask = *(*uint32)(unsafe.Pointer(&ring.sqRing[p.sqOff.ringMask]))
+	ring.sqArray = unsafe.Pointer(&ring.sqRing[p.sqOff.array])
+	ring.cqHead = (*uint32)(unsafe.Pointer(&ring.cqRing[p.cqOff.head]))
+	ring.cqTail = (*uint32)(unsafe.Pointer(&ring.cqRing[p.cqOff.tail]))
+	ring.cqMask = *(*uint32)(unsafe.Pointer(&ring.cqRing[p.cqOff.ringMask]))
+	return ring, nil
+}
+
+// Function which queues a read of len(buf) bytes at the offset of the file and submits it
+// The buffer must stay referenced until the completion of the read is reaped
+func (r *ioUring) submitRead(fd int, buf []byte, offset int64, userData uint64) error {
+	tail := atomic.LoadUint32(r.sqTail)
+	idx := tail & r.sqMask
+	sqe := r.sqes[idx*ioUringSqeSize : (idx+1)*ioUringSqeSize]
+	clear(sqe)
+	sqe[0] = ioringOpRead
+	*(*int32)(unsafe.Pointer(&sqe[4])) = int32(fd)
+	*(*uint64)(unsafe.Pointer(&sqe[8])) = uint64(offset)
+	*(*uint64)(unsafe.Pointer(&sqe[16])) = uint64(uintptr(unsafe.Pointer(&buf[0])))
+	*(*uint32)(unsafe.Pointer(&sqe[24])) = uint32(len(buf))
+	*(*uint64)(unsafe.Pointer(&sqe[32])) = userData
+	*(*uint32)(unsafe.Add(r.sqArray, idx*4)) = idx
+	atomic.StoreUint32(r.sqTail, tail+1)
+
+	for {
+		_, _, errno := syscall.Syscall6(sysIoUringEnter, uintptr(r.fd), 1, 0, 0, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return fmt.Errorf("io_uring_enter: %w", errno)
+		}
+		return nil
+	}
+}
+
+// Function which waits for the next completion and returns its user data and result
+// (the number of bytes read or a negative errno)
+func (r *ioUring) waitCompletion() (uint64, int32, error) {
+	for {
+		head := atomic.LoadUint32(r.cqHead)
+		if head != atomic.LoadUint32(r.cqTail) {
+			cqe := unsafe.Pointer(&r.cqRing[r.params.cqOff.cqes+(head&r.cqMask)*ioUringCqeSize])
+			userData := *(*uint64)(cqe)
+			res := *(*int32)(unsafe.Add(cqe, 8))
+			atomic.StoreUint32(r.cqHead, head+1)
+			return userData, res, nil
+		}
+		_, _, errno := syscall.Syscall6(sysIoUringEnter, uintptr(r.fd), 0, 1, ioringEnterGetEvents, 0, 0)
+		if errno != 0 && errno != syscall.EINTR {
+			return 0, 0, fmt.Errorf("io_uring_enter: %w", errno)
+		}
+	}
+}
+
+func (r *ioUring) Close() error {
+	for _, mem := range [][]byte{r.sqes, r.cqRing, r.sqRing} {
+		if mem != nil {
+			syscall.Munmap(mem)
+		}
+	}
+	return syscall.Close(r.fd)
+}
+
+// Function which reports whether io_uring can be used, it's missing on old kernels (before 5.6 for
+// IORING_OP_READ) and is often disabled in containers by seccomp or the io_uring_disabled sysctl
+func ioUringAvailable() error {
+	ring, err := newIoUring(1)
+	if err != nil {
+		return err
+	}
+	return ring.Close()
+}
+
+// Reader of a file which keeps IOURING_DEPTH reads of the following blocks in flight with io_uring,
+// so the disk works on the next blocks while the current one is parsed
+// Every block has its own buffer, the blocks are returned in the file order whatever order they complete in
+type ioUringReader struct {
+	file    *os.File
+	ring    *ioUring
+	size    int64                 // Size of the file when it was opened, the reads stop there
+	next    int64                 // Offset of the next block to submit
+	buffers [IOURING_DEPTH][]byte // Buffer of every block in flight
+	offsets [IOURING_DEPTH]int64  // File offset of the block in each buffer
+	results [IOURING_DEPTH]int32  // Result of the completed read, -1 while in flight
+	done    [IOURING_DEPTH]bool   // The read of the buffer completed
+	active  [IOURING_DEPTH]bool   // A read was submitted to the buffer
+	current int                   // Buffer which is being returned
+	pos     int                   // Bytes of the current buffer already returned
+}
+
+// Function which opens the file for reading with io_uring from the given position
+func openIoUringReader(path string, pos int64) (*ioUringReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	ring, err := newIoUring(IOURING_DEPTH)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	r := &ioUringReader{file: file, ring: ring, size: info.Size(), next: pos}
+	for i := range r.buffers {
+		r.buffers[i] = make([]byte, IOURING_BLOCK_SIZE)
+		if err := r.submit(i); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Function which submits the read of the next block into the buffer, nothing is read past the end of the file
+func (r *ioUringReader) submit(i int) error {
+	r.active[i], r.done[i] = false, false
+	if r.next >= r.size {
+		return nil
+	}
+	r.offsets[i] = r.next
+	r.results[i] = -1
+	length := min(int64(IOURING_BLOCK_SIZE), r.size-r.next)
+	if err := r.ring.submitRead(int(r.file.Fd()), r.buffers[i][:length], r.next, uint64(i)); err != nil {
+		return err
+	}
+	r.next += length
+	r.active[i] = true
+	return nil
+}
+
+func (r *ioUringReader) Read(p []byte) (int, error) {
+	i := r.current
+	if !r.active[i] {
+		return 0, io.EOF
+	}
+	for !r.done[i] {
+		userData, res, err := r.ring.waitCompletion()
+		if err != nil {
+			return 0, err
+		}
+		r.results[userData], r.done[userData] = res, true
+	}
+	if r.results[i] < 0 {
+		return 0, fmt.Errorf("io_uring read of %s at %d: %w", r.file.Name(), r.offsets[i], syscall.Errno(-r.results[i]))
+	}
+
+	// a short read leaves a hole before the next block, which was already requested, so the rest is read directly
+	length := int(min(int64(IOURING_BLOCK_SIZE), r.size-r.offsets[i]))
+	if got := int(r.results[i]); got < length {
+		n, err := r.file.ReadAt(r.buffers[i][got:length], r.offsets[i]+int64(got))
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		r.results[i] = int32(got + n)
+	}
+
+	n := copy(p, r.buffers[i][r.pos:r.results[i]])
+	r.pos += n
+	if r.pos == int(r.results[i]) {
+		r.pos = 0
+		r.current = (i + 1) % IOURING_DEPTH
+		if err := r.submit(i); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close waits for the reads still in flight, the kernel must not write into the buffers after they're released
+func (r *ioUringReader) Close() error {
+	for i := range r.active {
+		for r.active[i] && !r.done[i] {
+			userData, res, err := r.ring.waitCompletion()
+			if err != nil {
+				break
+			}
+			r.results[userData], r.done[userData] = res, true
+		}
+	}
+	r.ring.Close()
+	return r.file.Close()
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// io_uring is a linux interface, elsewhere the files are always read with the regular reader
+func ioUringAvailable() error {
+	return errors.New("io_uring is only available on linux")
+}
+
+func openIoUringReader(path string, pos int64) (io.ReadCloser, error) {
+	return nil, ioUringAvailable()
+}
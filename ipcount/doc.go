@@ -0,0 +1,9 @@
+// Package ipcount holds the parts of the unique IP counter for code which embeds it instead of
+// running the command line tool, e.g. a service counting the addresses of its own streams
+//
+// The embedders supply their own line formats by implementing LineParser, or describe the encoding
+// of the four fields of an address with a FieldSpec
+//
+// CountAndStream and its variants count the unique addresses of a reader in a SparseSet and stream
+// every address the first time it's seen, CountFromReaders counts the union of several readers at once
+package ipcount
@@ -0,0 +1,122 @@
+package ipcount
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+)
+
+const MAX_LINE_BYTES = 64 * 1024 // Longest line which is parsed, the longer ones are dropped (no address is that long)
+
+// The functions don't use any global state, so they can be called any number of times and concurrently
+
+// Function which counts the unique IPv4 addresses of the dotted-quad lines read from r and calls
+// onUnique with every address the first time it's seen, while reading, in the order of arrival
+// The addresses are validated like -compat-netip of the command line tool, lines without one are skipped
+// The set is sparse, so the memory grows with the number of distinct /16s instead of the 512MB array
+// onUnique runs on the calling goroutine, a slow callback slows the reading down
+func CountAndStream(r io.Reader, onUnique func(ip uint32)) (uint64, error) {
+	return CountAndStreamParser(r, DottedDecimal, onUnique)
+}
+
+// Function which counts and streams the unique addresses like CountAndStream, of the lines in the layout
+// of the spec, e.g. FieldSpec{Separator: '.', Radix: 16} for C0.A8.00.01
+// The spec is checked before reading, an invalid one returns its error without reading r
+func CountAndStreamSpec(r io.Reader, spec FieldSpec, onUnique func(ip uint32)) (uint64, error) {
+	if err := spec.Validate(); err != nil {
+		return 0, err
+	}
+	return CountAndStreamParser(r, spec, onUnique)
+}
+
+// Function which counts and streams the unique addresses like CountAndStream, found in the lines by
+// the parser of the embedder, e.g. the address field of its own log format
+// The spaces and tabs around the lines are trimmed before they're parsed
+func CountAndStreamParser(r io.Reader, parser LineParser, onUnique func(ip uint32)) (uint64, error) {
+	set := NewSparseSet()
+	count := uint64(0)
+	err := scanReader(r, parser, func(ip uint32) {
+		if !set.AddNew(ip) {
+			return
+		}
+		count++
+		if onUnique != nil {
+			onUnique(ip)
+		}
+	})
+	return count, err
+}
+
+// Function which counts the union of the unique IPv4 addresses of all readers, read concurrently
+// by up to workers goroutines (the number of logical cores when workers is 0 or less), e.g. several
+// network connections. The lines are parsed like CountAndStream and the goroutines set the bits of one
+// sparse set with atomic ORs, like the chunks of a file
+// A failed reader doesn't stop the others, the count covers everything read and the error joins
+// the errors of the failed readers in the order of the readers
+func CountFromReaders(readers []io.Reader, workers int) (uint64, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	set := NewSparseSet()
+	errs := make([]error, len(readers))
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < min(workers, len(readers)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				errs[idx] = scanReader(readers[idx], DottedDecimal, set.Add)
+			}
+		}()
+	}
+	for idx := range readers {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	return set.Count(), errors.Join(errs...)
+}
+
+// Function which calls fn with the address of every line of r parsed by the parser, the lines without one are skipped
+// A line longer than MAX_LINE_BYTES is dropped piece by piece as it's read, it's never buffered whole
+func scanReader(r io.Reader, parser LineParser, fn func(ip uint32)) error {
+	reader := bufio.NewReaderSize(r, MAX_LINE_BYTES)
+	dropping := false // The rest of an overlong line is being read
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			dropping = true
+			continue
+		}
+		if !dropping && len(line) > 0 {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte{'\n'}), []byte{'\r'})
+			if ip, ok := parser.Parse(TrimBlanks(line)); ok {
+				fn(ip)
+			}
+		}
+		dropping = false
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Function which strips the spaces and tabs around the line, e.g. of the indented addresses
+func TrimBlanks(line []byte) []byte {
+	for len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+		line = line[1:]
+	}
+	for len(line) > 0 && (line[len(line)-1] == ' ' || line[len(line)-1] == '\t') {
+		line = line[:len(line)-1]
+	}
+	return line
+}
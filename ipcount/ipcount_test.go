@@ -0,0 +1,110 @@
+package ipcount
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// The lines of a custom spec are counted and streamed like the dotted ones, an invalid spec reads nothing
+func TestCountAndStreamSpec(t *testing.T) {
+	input := "C0.A8.00.01\nc0.a8.0.1\r\n0A.00.00.01\n192.168.0.1\n\nFF.FF.FF.FF"
+	streamed := []uint32{}
+	count, err := CountAndStreamSpec(strings.NewReader(input), FieldSpec{Separator: '.', Radix: 16}, func(ip uint32) {
+		streamed = append(streamed, ip)
+	})
+	if err != nil || count != 3 || fmt.Sprintf("%08x", streamed) != "[c0a80001 0a000001 ffffffff]" {
+		t.Errorf("count = %d, streamed %08x, error %v, want the 3 hexadecimal addresses", count, streamed, err)
+	}
+
+	if count, err := CountAndStream(strings.NewReader(input), nil); err != nil || count != 1 {
+		t.Errorf("DottedDecimal: count = %d, error %v, want 1", count, err)
+	}
+
+	reader := strings.NewReader(input)
+	if _, err := CountAndStreamSpec(reader, FieldSpec{Separator: '.', Radix: 20}, nil); err == nil || reader.Len() != len(input) {
+		t.Errorf("invalid spec: error %v, %d bytes read", err, len(input)-reader.Len())
+	}
+}
+
+// Parser which takes the address from the second space-separated field, like an access log of an embedder
+type secondField struct{}
+
+func (secondField) Parse(line []byte) (uint32, bool) {
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 {
+		return 0, false
+	}
+	return DottedDecimal.Parse([]byte(fields[1]))
+}
+
+// The lines are parsed by the parser of the embedder, blanks are trimmed and an overlong line is dropped
+// without losing the lines after it
+func TestCountAndStreamParser(t *testing.T) {
+	input := "GET 10.0.0.1 /\n  POST 10.0.0.2 /form\t\n" + strings.Repeat("x", 3*MAX_LINE_BYTES) + " 10.0.0.3\nGET 10.0.0.1 /\nPUT 10.0.0.4"
+	streamed := []uint32{}
+	count, err := CountAndStreamParser(strings.NewReader(input), secondField{}, func(ip uint32) {
+		streamed = append(streamed, ip)
+	})
+	if err != nil || count != 3 || fmt.Sprintf("%08x", streamed) != "[0a000001 0a000002 0a000004]" {
+		t.Errorf("count = %d, streamed %08x, error %v, want the 3 addresses of the short lines", count, streamed, err)
+	}
+}
+
+// Function which returns the readers of pipes, each fed by its own goroutine like a network connection,
+// every reader overlaps half of the addresses of the next one
+func pipeReaders(readers int, perReader int) []io.Reader {
+	streams := make([]io.Reader, readers)
+	for r := range readers {
+		pr, pw := io.Pipe()
+		streams[r] = pr
+		go func() {
+			for i := range perReader {
+				ip := r*perReader/2 + i
+				fmt.Fprintf(pw, "10.%d.%d.%d\n", ip>>16&255, ip>>8&255, ip&255)
+			}
+			pw.Close()
+		}()
+	}
+	return streams
+}
+
+// Many readers are read at the same time by the workers and their overlapping addresses count once
+// Run under -race: the workers share one set
+func TestCountFromReadersConcurrent(t *testing.T) {
+	const readers = 64
+	const perReader = 2000
+	for _, workers := range []int{1, 8, readers, 0} {
+		count, err := CountFromReaders(pipeReaders(readers, perReader), workers)
+		if err != nil {
+			t.Fatalf("%d workers: %v", workers, err)
+		}
+		if want := uint64((readers + 1) * perReader / 2); count != want {
+			t.Errorf("%d workers: count = %d, want %d", workers, count, want)
+		}
+	}
+}
+
+// The failed readers don't stop the others, their errors are joined in the order of the readers
+func TestCountFromReadersErrors(t *testing.T) {
+	first, second := errors.New("connection reset"), errors.New("timeout")
+	readers := []io.Reader{
+		strings.NewReader("1.1.1.1\n2.2.2.2\n"),
+		io.MultiReader(strings.NewReader("3.3.3.3\n"), iotest.ErrReader(first)),
+		strings.NewReader("2.2.2.2\nnot an address\n4.4.4.4"),
+		iotest.ErrReader(second),
+	}
+	count, err := CountFromReaders(readers, 2)
+	if count != 4 {
+		t.Errorf("count = %d, want 4", count)
+	}
+	if !errors.Is(err, first) || !errors.Is(err, second) || err.Error() != "connection reset\ntimeout" {
+		t.Errorf("error %q, want both errors in the order of the readers", err)
+	}
+	if count, err := CountFromReaders(nil, 4); count != 0 || err != nil {
+		t.Errorf("no readers: %d, %v", count, err)
+	}
+}
@@ -0,0 +1,82 @@
+package ipcount
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// ParseDottedQuad accepts exactly the IPv4 addresses netip.ParseAddr accepts, with the same value
+func FuzzStrictMatchesNetip(f *testing.F) {
+	for _, seed := range []string{"1.2.3.4", "0.0.0.0", "255.255.255.255", "01.2.3.4", "1.2.3.04", "0.0.0.00", "256.1.1.1",
+		"1..2.3", ".1.2.3", "1.2.3.", "1.2.3.4 ", " 1.2.3.4", "1.2.3.4.5", "1.2.3", "1.2.3.4%eth0", "::1", "::ffff:1.2.3.4", "1.2.3.4\x00", ""} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, line []byte) {
+		ip, ok := ParseDottedQuad(line)
+		addr, err := netip.ParseAddr(string(line))
+		want := err == nil && addr.Is4()
+		if ok != want {
+			t.Fatalf("%q accepted = %v, netip accepted = %v (%v)", line, ok, want, err)
+		}
+		if !ok {
+			return
+		}
+		if octets := addr.As4(); ip != uint32(octets[0])<<24|uint32(octets[1])<<16|uint32(octets[2])<<8|uint32(octets[3]) {
+			t.Fatalf("%q parsed to %08x, netip gave %v", line, ip, addr)
+		}
+	})
+}
+
+func TestFieldSpecParse(t *testing.T) {
+	hex := FieldSpec{Separator: '.', Radix: 16}
+	tests := []struct {
+		spec FieldSpec
+		line string
+		ip   uint32
+		ok   bool
+	}{
+		{hex, "C0.A8.00.01", 0xC0A80001, true},
+		{hex, "c0.a8.0.1", 0xC0A80001, true},
+		{hex, "FF.FF.FF.FF", 0xFFFFFFFF, true},
+		{hex, "00.00.00.00", 0, true},
+		{hex, "100.00.00.01", 0, false}, // above 255
+		{hex, "C0.A8.00", 0, false},
+		{hex, "C0.A8.00.01.02", 0, false},
+		{hex, "C0.A8..01", 0, false},
+		{hex, "C0.A8.00.", 0, false},
+		{hex, "C0.G8.00.01", 0, false},
+		{hex, "C0.A8.00.01 ", 0, false},
+		{FieldSpec{Separator: '-', Radix: 10}, "192-168-000-001", 0xC0A80001, true},
+		{FieldSpec{Separator: ':', Radix: 2}, "11000000:10101000:0:1", 0xC0A80001, true},
+		{FieldSpec{Separator: ':', Radix: 8}, "300:250:0:1", 0xC0A80001, true},
+		{DottedDecimal, "192.168.0.1", 0xC0A80001, true},
+		{DottedDecimal, "192.168.000.001", 0, false}, // the leading zeros netip.ParseAddr rejects
+		{DottedDecimal, "C0.A8.00.01", 0, false},
+		{FieldSpec{Separator: 'a', Radix: 16}, "1a2a3a4", 0, false}, // invalid spec
+	}
+	for _, test := range tests {
+		ip, ok := test.spec.Parse([]byte(test.line))
+		if ok != test.ok || (ok && ip != test.ip) {
+			t.Errorf("%+v.Parse(%q) = %08x, %v, want %08x, %v", test.spec, test.line, ip, ok, test.ip, test.ok)
+		}
+	}
+}
+
+func TestFieldSpecValidate(t *testing.T) {
+	for spec, ok := range map[FieldSpec]bool{
+		DottedDecimal:               true,
+		{Separator: '.', Radix: 16}: true,
+		{Separator: 'g', Radix: 16}: true,
+		{Separator: 'f', Radix: 16}: false,
+		{Separator: 'F', Radix: 16}: false,
+		{Separator: '9', Radix: 10}: false,
+		{Separator: '9', Radix: 8}:  true,
+		{Separator: '.', Radix: 1}:  false,
+		{Separator: '.', Radix: 17}: false,
+		{}:                          false,
+	} {
+		if err := spec.Validate(); (err == nil) != ok {
+			t.Errorf("%+v.Validate() = %v", spec, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package ipcount
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+const (
+	SPARSE_BLOCKS       = 65536 // One block per /16
+	SPARSE_BLOCK_WORDS  = 2048  // 2^16 bits = 8KB per block
+	SPARSE_GROUPS       = 256   // One table of block pointers per /8
+	SPARSE_GROUP_BLOCKS = 256   // Blocks of a /8, 2KB of pointers per table
+)
+
+// Set of the IP addresses (or networks) filled by the workers
+// Add and Contains must be safe for concurrent use, Count and ForEach are called after all workers
+// are done, CountApprox is the count for live snapshots taken while the workers are still adding
+// Counts are uint64 because the full address space has 2^32 addresses, one more than fits in uint32
+type Set interface {
+	Add(ip uint32)
+	Contains(ip uint32) bool
+	Count() uint64
+	CountApprox() uint64
+	ForEach(fn func(ip uint32))
+}
+
+// Set which stores the bits of every /16 in its own 8KB block allocated on the first address of the /16
+// The memory is proportional to the number of distinct /16s, which suits data confined to a few networks
+// The pointers to the blocks are in a 2KB table per /8, also allocated on the first address, so an empty
+// set takes 2KB and many small sets (one per time window of -windows-csv) stay cheap
+// Tables and blocks are published with compare-and-swap, so concurrent workers never allocate one twice
+type SparseSet struct {
+	groups [SPARSE_GROUPS]atomic.Pointer[sparseGroup]
+}
+
+type sparseGroup [SPARSE_GROUP_BLOCKS]atomic.Pointer[[SPARSE_BLOCK_WORDS]uint32]
+
+func NewSparseSet() *SparseSet {
+	return &SparseSet{}
+}
+
+// Function which returns the block of the /16, allocating it and the table of its /8 when they're missing
+// When two workers race on an allocation the loser drops its copy and uses the published one
+func (s *SparseSet) block(idx uint32) *[SPARSE_BLOCK_WORDS]uint32 {
+	group := s.groups[idx>>8].Load()
+	if group == nil {
+		group = new(sparseGroup)
+		if !s.groups[idx>>8].CompareAndSwap(nil, group) {
+			group = s.groups[idx>>8].Load()
+		}
+	}
+	if block := group[idx&255].Load(); block != nil {
+		return block
+	}
+	block := new([SPARSE_BLOCK_WORDS]uint32)
+	if group[idx&255].CompareAndSwap(nil, block) {
+		return block
+	}
+	return group[idx&255].Load()
+}
+
+// Function which returns the block of the /16 without allocating, nil when the /16 has no address
+func (s *SparseSet) existingBlock(idx uint32) *[SPARSE_BLOCK_WORDS]uint32 {
+	if group := s.groups[idx>>8].Load(); group != nil {
+		return group[idx&255].Load()
+	}
+	return nil
+}
+
+// Function which calls fn with the index of the /16 and the block of every allocated block in ascending order
+func (s *SparseSet) forEachBlock(fn func(idx uint32, block *[SPARSE_BLOCK_WORDS]uint32)) {
+	for i := range s.groups {
+		group := s.groups[i].Load()
+		if group == nil {
+			continue
+		}
+		for j := range group {
+			if block := group[j].Load(); block != nil {
+				fn(uint32(i)<<8|uint32(j), block)
+			}
+		}
+	}
+}
+
+// Add is safe for concurrent use: the bit is set with an atomic OR, because a plain
+// read-modify-write would lose the bits of other workers updating the same word
+func (s *SparseSet) Add(ip uint32) {
+	atomic.OrUint32(&s.block(ip >> 16)[ip>>5&(SPARSE_BLOCK_WORDS-1)], 1<<(ip&31))
+}
+
+// AddNew adds the address like Add and reports whether it was new, the test and the set are one atomic OR,
+// so of the workers adding the same address at the same time exactly one sees it as new
+func (s *SparseSet) AddNew(ip uint32) bool {
+	bit := uint32(1) << (ip & 31)
+	return atomic.OrUint32(&s.block(ip >> 16)[ip>>5&(SPARSE_BLOCK_WORDS-1)], bit)&bit == 0
+}
+
+func (s *SparseSet) Contains(ip uint32) bool {
+	block := s.existingBlock(ip >> 16)
+	return block != nil && atomic.LoadUint32(&block[ip>>5&(SPARSE_BLOCK_WORDS-1)])&(1<<(ip&31)) != 0
+}
+
+func (s *SparseSet) Count() uint64 {
+	var count uint64 = 0
+	s.forEachBlock(func(_ uint32, block *[SPARSE_BLOCK_WORDS]uint32) {
+		for _, word := range block {
+			count += uint64(bits.OnesCount32(word))
+		}
+	})
+	return count
+}
+
+// CountApprox counts the set while the workers may still be adding to it, every word is read with an
+// atomic load, so the result is between the counts at the start and at the end of the call
+func (s *SparseSet) CountApprox() uint64 {
+	var count uint64 = 0
+	s.forEachBlock(func(_ uint32, block *[SPARSE_BLOCK_WORDS]uint32) {
+		for j := range block {
+			count += uint64(bits.OnesCount32(atomic.LoadUint32(&block[j])))
+		}
+	})
+	return count
+}
+
+// ForEach calls fn with every address of the set in ascending order, the zero words are skipped
+func (s *SparseSet) ForEach(fn func(ip uint32)) {
+	s.forEachBlock(func(idx uint32, block *[SPARSE_BLOCK_WORDS]uint32) {
+		for wordIdx, word := range block {
+			for word != 0 {
+				bitIdx := bits.TrailingZeros32(word)
+				word &= word - 1
+				fn(idx<<16 | uint32(wordIdx)<<5 | uint32(bitIdx))
+			}
+		}
+	})
+}
+
+// Function which returns the number of allocated blocks, i.e. the number of distinct /16s
+func (s *SparseSet) BlockCount() int {
+	count := 0
+	s.forEachBlock(func(uint32, *[SPARSE_BLOCK_WORDS]uint32) {
+		count++
+	})
+	return count
+}
@@ -0,0 +1,95 @@
+package ipcount
+
+import (
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+// An empty sparse set holds only the table of its /8s, not a pointer per /16
+func TestSparseSetEmptySize(t *testing.T) {
+	if size := unsafe.Sizeof(SparseSet{}); size > 4096 {
+		t.Fatalf("empty sparse set takes %d bytes, want at most 4096", size)
+	}
+}
+
+// Addresses of different /8s and /16s land in their own blocks and come back in ascending order
+func TestSparseSetLazyBlocks(t *testing.T) {
+	s := NewSparseSet()
+	ips := []uint32{0x00000000, 0x0000ffff, 0x00010000, 0x0a000001, 0x0aff0001, 0xffffffff}
+	for i := len(ips) - 1; i >= 0; i-- {
+		s.Add(ips[i])
+	}
+	if got := s.BlockCount(); got != 5 {
+		t.Fatalf("%d blocks, want 5", got)
+	}
+	if s.Contains(0x0b000000) || s.existingBlock(0x0b00) != nil {
+		t.Fatal("lookup of a missing /8 allocated or found a block")
+	}
+	var got []uint32
+	s.ForEach(func(ip uint32) { got = append(got, ip) })
+	if len(got) != len(ips) {
+		t.Fatalf("ForEach returned %v, want %v", got, ips)
+	}
+	for i := range ips {
+		if got[i] != ips[i] {
+			t.Fatalf("ForEach returned %v, want %v", got, ips)
+		}
+	}
+}
+
+// Goroutines released together add to the same fresh /16s of the sparse set, so they race to allocate
+// the table of the /8 and the block of the /16: every bit must land in the one published block
+// Run under -race: a block allocated without compare-and-swap is reported or drops the bits of the loser
+func TestSparseSetConcurrentAllocation(t *testing.T) {
+	const goroutines = 16
+	const blocks = 64
+	s := NewSparseSet()
+	start := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for b := range blocks {
+				// /16s spread over several /8s, so the tables are allocated concurrently too
+				high := uint32(b%4)<<24 | uint32(b)<<16
+				s.Add(high | uint32(g))
+				s.Add(high | uint32(g)<<8 | 0xff)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := s.BlockCount(); got != blocks {
+		t.Errorf("%d blocks, want %d", got, blocks)
+	}
+	if count := s.Count(); count != goroutines*blocks*2 {
+		t.Errorf("count = %d, want %d", count, goroutines*blocks*2)
+	}
+	if approx := s.CountApprox(); approx != s.Count() {
+		t.Errorf("CountApprox = %d, want %d", approx, s.Count())
+	}
+}
+
+// With every bit set the count is 2^32, one more than fits in uint32 (it used to wrap to 0)
+func TestFullAddressSpaceCount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocates 512MB")
+	}
+	s := NewSparseSet()
+	for i := range SPARSE_BLOCKS {
+		block := s.block(uint32(i))
+		for j := range block {
+			block[j] = ^uint32(0)
+		}
+	}
+	if count := s.Count(); count != 1<<32 {
+		t.Errorf("Count() = %d, want 2^32", count)
+	}
+	if count := s.CountApprox(); count != 1<<32 {
+		t.Errorf("CountApprox() = %d, want 2^32", count)
+	}
+}
@@ -2,28 +2,272 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
+	"math"
 	"math/bits"
+	"net/netip"
 	"os"
+	"os/signal"
 	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unsafe"
+
+	"Lightspeed_Task/ipcount"
 )
 
 const (
-	POW2_27       = 134217728       // 2^27
-	BUFFER_SIZE   = 4 * 1024 * 1024 // 4MB
-	BYTES_OVERLAP = 64              // 64 bytes overlap between threads
+	POW2_27      = 134217728       // 2^27
+	BUFFER_SIZE  = 4 * 1024 * 1024 // 4MB
+	CANCEL_CHECK = 1024            // Number of lines between the worker cancellation checks
+
+	// Threads per logical core of -t max: a reading thread blocked on a slow disk or a network filesystem
+	// leaves its core idle, 4 threads per core keep the cores parsing while up to 3/4 of the reads wait
+	THREADS_PER_CPU_MAX = 4
+
+	ESTIMATE_SAMPLE_BYTES = 64 * 1024 * 1024 // 64MB prefix of the file read by -estimate-first
+	ESTIMATE_SATURATION   = 0.9              // Share of the address space at which the estimate warns
 )
 
-var ips = make([]uint32, POW2_27) // 2^27 * uint32 = 512MB
+var littleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1 // Byte order of the CPU, see wideWords
+
+var ips Set // Up to 2^27 * uint32 = 512MB for the dense set, allocated in processIPFile
+
+var allowed Set // IPs which are counted exclusively, nil unless -only-file is set
+
+var arrival *arrivalWriter // Writer of the new IPs while reading, nil unless -count-unique-and-write-in-one-pass is set
+var newIps newAdder        // The set as a newAdder for arrival, nil with it
+
+var fileIps Set // IPs of the file being read, nil unless -count-per-file is set
+
+var excluded []uint32 // Placeholder IPs which are never counted, empty unless -exclude-zero is set
+
+var parseOnly bool // Lines are parsed but not added to the set, set by -parse-only
+
+var normalizeWhitespace bool // The spaces and tabs around the lines are trimmed before parsing, set by -normalize-whitespace
+
+var multiParser MultiLineParser // Parser which finds every IP of the line, nil unless the parser supports it (-regex)
+
+var rangeParser RangeLineParser // Parser of the address ranges, nil unless the parser supports it (-ranges, -cidr)
+
+var maxRange uint64                 // Largest range which is expanded, set by -max-range
+var expandedAddresses atomic.Uint64 // Addresses added by the expanded ranges
+var oversizedRanges atomic.Uint64   // Ranges which were skipped because they are larger than maxRange
+
+var occurrences *occurrenceCounter // Counter of the frequent IPs, nil unless -min-occurrences is set
+
+var duplicates *dupWindow // Window of the recent IPs, nil unless -dup-window is set
+
+var rolling *windowCounter // Distinct IPs of the last -count-window, nil unless it's set
+
+// Pools of the 4MB buffers of the chunks, runs with many chunks (small -chunk-size, many files) reuse
+// them instead of allocating a new reader and scanner buffer for every chunk
+var readerPool = sync.Pool{New: func() any { return bufio.NewReaderSize(nil, BUFFER_SIZE) }}
+var scanBufferPool = sync.Pool{New: func() any { buf := make([]byte, BUFFER_SIZE); return &buf }}
+
+var skippedLines atomic.Uint64   // Lines which were not counted because they can't be an IP address
+var totalLines atomic.Uint64     // All lines read by the workers
+var processedBytes atomic.Uint64 // Bytes of the input files processed by the workers, reported by -progress-json
 
 type Config struct {
-	filePath   string // Path to the input file
-	numThreads int    // Number of threads
+	filePaths        []string      // Input files, the -f file followed by the positional arguments
+	countPerFile     bool          // Read the files one by one and report the unique and new IPs of every file
+	mergeSorted      bool          // Count the union of the already sorted files by merging them, without the bitset
+	alsoStdin        bool          // Also read the lines piped to stdin into the same set, after the files
+	addresses        []string      // IP addresses given directly on the command line
+	onlyPath         string        // Path to the file with the only IP addresses to count
+	excluded         []netip.Addr  // Placeholder IP addresses which are never counted
+	parser           LineParser    // Parser which extracts the IP address from a line
+	delimiter        byte          // Separator of the fields of -in-format auto (0 = whitespace), other parsers have none
+	binary           bool          // The files are 4-byte big-endian records instead of lines, the parser is not used
+	numThreads       int           // Number of threads
+	networkBits      int           // Number of leading bits which identify a network (32 = count hosts)
+	warmup           bool          // Pre-fault the bitset memory before reading
+	backend          string        // Set implementation: array, sparse, roaring, hashset, approx (-approx) or auto (picked from a sample)
+	minOccurs        int           // Also count the IPs seen at least this many times (0 = disabled)
+	failFast         bool          // Stop all workers on the first error
+	estimate         bool          // Estimate the unique count from a sample of the file before exact counting
+	maxErrors        int           // Stop all workers once this many errors are collected (0 = no limit)
+	maxMemory        uint64        // Stop all workers before the process holds this many bytes (0 = no limit)
+	ignoreErrors     bool          // Exit with status 0 and the partial count even when some reads failed
+	chunkSize        int           // Size of the file chunks in bytes (0 = one chunk per thread)
+	maxLineBytes     int           // Lines longer than this are dropped as skipped lines, at most BUFFER_SIZE
+	plan             bool          // Print the split of the files into chunks, the threads and the buffers without reading
+	minThreadBytes   int           // Minimum size of the default per-thread chunk, fewer threads are used for smaller inputs
+	writePath        string        // Path of the file to write the unique IP addresses to
+	onePass          bool          // Write the -write addresses while reading, in the order of arrival
+	binaryPath       string        // Path of the file to write the unique IP addresses to as 4-byte big-endian records
+	binaryChecksum   bool          // Write the -write-binary file as a dump with a header and the checksums of its frames
+	baselinePath     string        // Path of the -write-binary dump of an earlier run to compare the input with
+	addedPath        string        // Path of the file to write the addresses absent from the baseline to
+	removedPath      string        // Path of the file to write the addresses of the baseline absent from the input to
+	sorted           bool          // Verify that the written IP addresses are in ascending order
+	formatIp         ipFormatFunc  // Formatter of the written IP addresses
+	output           string        // Format of the result printed to stdout: text or json (one JSON object)
+	human            bool          // Follow the unique count of the text summary with its digit-grouped and SI forms
+	quiet            bool          // Print only the raw unique count (the parsed lines with -parse-only)
+	cpuProfile       string        // Path of the CPU profile of the count phase, empty when not profiled
+	memProfile       string        // Path of the heap profile written after the count phase, empty when not profiled
+	octets           bool          // Report the per octet histograms of the unique IPs
+	classes          bool          // Report the unique IPs of each classful range (A to E)
+	prefixSweep      bool          // Report the distinct networks of every prefix length from -network-bits down to /8
+	specialUse       bool          // Report the unique IPs of each special-use category (private, loopback, ...) and the public rest
+	asnDbPath        string        // Path of the MaxMind ASN database to group the unique IPs by autonomous system with
+	asnTop           int           // Number of the autonomous systems with the most unique IPs reported with -asn-db
+	geoDbPath        string        // Path of the MaxMind country database to group the unique IPs by country with
+	ioUring          bool          // Read the plain files with io_uring (linux), reads ahead IOURING_DEPTH blocks
+	maxRange         uint64        // Largest address range of -ranges or -cidr which is expanded, larger ones are skipped
+	blocklistPath    string        // Path of the blocklist file to export the unique IP addresses to
+	blocklistHeader  bool          // Start the blocklist with the # comment header
+	shardDir         string        // Directory to write the unique IP addresses to, one file per /8
+	gaps             netip.Prefix  // Range whose addresses missing from the set are listed, invalid when -gaps is not set
+	follow           bool          // Keep reading the lines appended to the file until interrupted
+	followInterval   time.Duration // How often the count is printed in the follow mode
+	countWindow      time.Duration // Length of the rolling window whose unique count is printed in the follow mode (0 = disabled)
+	parseOnly        bool          // Only parse the lines to measure the parser throughput
+	collisionSample  int           // Number of the lines checked for different lines parsed to the same address (0 = disabled)
+	normalize        bool          // Trim the spaces and tabs around the lines before parsing them
+	expect           int64         // Expected unique count, the program fails when the result differs (-1 = no check)
+	skipReadErrors   bool          // Report a read error which persists after the retries and go on past the unreadable bytes
+	shortReads       bool          // Verify the seek of a chunk and fill its first read, retrying the reads which return nothing
+	readRetries      int           // Number of retries of a failed open, seek or read of the chunk (0 = fail immediately)
+	dupWindow        int           // Number of recent addresses checked for repeats (0 = disabled), forces a single thread
+	windowsCsv       string        // Path of the CSV with the unique count of every time window, empty when not written
+	windowSize       time.Duration // Length of the time windows of -windows-csv
+	progressPath     string        // Destination of the JSON progress events, a path or fd:N
+	progressInterval time.Duration // How often the JSON progress events are written
+	watch            time.Duration // How often the live unique count is printed to stdout (0 = never), the summary goes to stderr
+}
+
+// Flag value of the thread count: a number or one of the keywords
+// auto (the number of logical CPU cores) and max (THREADS_PER_CPU_MAX threads per core)
+type threadCount struct {
+	value   int    // Resolved number of threads
+	keyword string // Keyword the number was resolved from, empty for a number
+}
+
+func (t *threadCount) String() string {
+	if t.keyword != "" {
+		return t.keyword
+	}
+	return strconv.Itoa(t.value)
+}
+
+func (t *threadCount) Set(value string) error {
+	switch value {
+	case "auto":
+		t.value = runtime.NumCPU()
+	case "max":
+		t.value = runtime.NumCPU() * THREADS_PER_CPU_MAX
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("expected a number of threads, auto or max")
+		}
+		t.value, t.keyword = n, ""
+		return nil
+	}
+	t.keyword = value
+	return nil
+}
+
+// Flag value of -delimiter, one character or \t, 0 is the default whitespace
+type fieldDelimiter struct {
+	value byte
+}
+
+func (d *fieldDelimiter) String() string {
+	switch d.value {
+	case 0:
+		return ""
+	case '\t':
+		return `\t`
+	}
+	return string(d.value)
+}
+
+func (d *fieldDelimiter) Set(value string) error {
+	switch {
+	case value == `\t`:
+		d.value = '\t'
+	case len(value) == 1 && value != "." && value != "\n":
+		d.value = value[0]
+	default:
+		return errors.New(`expected one character (not . or a newline) or \t`)
+	}
+	return nil
+}
+
+// Flag value of -gaps, a CIDR range with the host bits cleared, invalid when the flag is not set
+type cidrRange struct {
+	prefix netip.Prefix
+}
+
+func (r *cidrRange) String() string {
+	if !r.prefix.IsValid() {
+		return ""
+	}
+	return r.prefix.String()
+}
+
+func (r *cidrRange) Set(value string) error {
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return errors.New("expected a CIDR range like 10.0.0.0/24")
+	}
+	r.prefix = prefix.Masked()
+	return nil
+}
+
+// Flag value of -sentinels, comma separated addresses
+type addressList struct {
+	addrs []netip.Addr
+}
+
+func (l *addressList) String() string {
+	values := []string{}
+	for _, addr := range l.addrs {
+		values = append(values, addr.String())
+	}
+	return strings.Join(values, ",")
+}
+
+func (l *addressList) Set(value string) error {
+	l.addrs = nil
+	for _, field := range strings.Split(value, ",") {
+		addr, err := netip.ParseAddr(strings.TrimSpace(field))
+		if err != nil {
+			return fmt.Errorf("expected comma separated addresses, %q is not one", field)
+		}
+		l.addrs = append(l.addrs, addr)
+	}
+	return nil
+}
+
+// Flag value which collects every occurrence of a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
 // Command line interface for the program
@@ -31,45 +275,541 @@ type Config struct {
 func cli() Config {
 	help := flag.Bool("h", false, "Display usage information")
 	helpLong := flag.Bool("help", false, "Display usage information")
-	numThreads := flag.Int("t", runtime.NumCPU(), "Set number of threads (Default: Number of CPU logical cores)")
-	numThreadsLong := flag.Int("threads", runtime.NumCPU(), "Set number of threads (Default: Number of CPU logical cores)")
+	version := flag.Bool("version", false, "Print the version and the build information")
+	numThreads := &threadCount{value: runtime.NumCPU()}
+	flag.Var(numThreads, "t", "Set number of threads, auto or max (Default: Number of CPU logical cores)")
+	numThreadsLong := &threadCount{value: runtime.NumCPU()}
+	flag.Var(numThreadsLong, "threads", "Set number of threads, auto or max (Default: Number of CPU logical cores)")
 	filePath := flag.String("f", "", "Input file path (mandatory)")
 	filePathLong := flag.String("file", "", "Input file path (mandatory)")
+	countPerFile := flag.Bool("count-per-file", false, "Report the unique and the new unique IPs of every file, the files are read one by one")
+	alsoStdin := flag.Bool("also-stdin", false, "After the files, also count the IP addresses piped to stdin into the same set")
+	mergeSorted := flag.Bool("merge-sorted", false, "Count the union of already sorted IP files by merging them, without the 512MB bitset")
+	addresses := stringList{}
+	flag.Var(&addresses, "ip", "IP address to count, can be repeated")
+	writePath := flag.String("write", "", "Write the unique IP addresses to the given file")
+	writeBinaryPath := flag.String("write-binary", "", "Write the unique IP addresses to the given file as 4-byte big-endian records")
+	binaryChecksum := flag.Bool("write-binary-checksum", false, "Write the -write-binary file as a dump with a header and the CRC32 of every 65536 records")
+	baselinePath := flag.String("baseline", "", "Report the IP addresses added and removed since the given -write-binary dump of an earlier run")
+	addedPath := flag.String("baseline-added", "", "Write the IP addresses absent from the -baseline dump to the given file")
+	removedPath := flag.String("baseline-removed", "", "Write the IP addresses of the -baseline dump absent from the input to the given file")
+	outFormat := flag.String("out-format", "dotted", "Format of the written IP addresses: dotted, int or hex")
+	output := flag.String("o", "text", "Format of the result printed to stdout: text or json")
+	human := flag.Bool("human", false, "Also print the unique count with thousands separators and an SI suffix, e.g. 29,895,434 / 29.9M")
+	quiet := flag.Bool("quiet", false, "Print only the raw unique count, for scripts")
+	blocklistPath := flag.String("export-blocklist", "", "Export the unique IP addresses as a firewall blocklist to the given file")
+	blocklistHeader := flag.Bool("blocklist-header", true, "Start the exported blocklist with # comments (date, source files, count)")
+	shardDir := flag.String("shard-output", "", "Write the unique IP addresses to one file per /8 in the given directory")
+	octets := flag.Bool("octet-distribution", false, "Report how many unique IPs have each value in each of the 4 octets")
+	prefixSweep := flag.Bool("count-distinct-per-prefix-length", false, "Report the distinct networks of every prefix length from -network-bits down to /8")
+	specialUse := flag.Bool("count-reserved-separately", false, "Report the unique IPs of each special-use range (private, loopback, multicast, ...) and the public rest")
+	asnDbPath := flag.String("asn-db", "", "Group the unique IPs by the autonomous systems of the given MaxMind ASN database (.mmdb)")
+	asnTop := flag.Int("asn-top", 10, "Number of the autonomous systems with the most unique IPs reported with -asn-db")
+	geoDbPath := flag.String("geo-db", "", "Group the unique IPs by the countries of the given MaxMind country database (.mmdb)")
+	classes := flag.Bool("ipv4-classes", false, "Report the unique IPs of each classful range, A to E")
+	gaps := &cidrRange{}
+	flag.Var(gaps, "gaps", "List the addresses of the given CIDR range which are not in the input")
+	onePass := flag.Bool("count-unique-and-write-in-one-pass", false, "Write the -write addresses while reading, in the order of arrival, without scanning the set afterwards")
+	sorted := flag.Bool("sorted", false, "Verify that the written IP addresses are in ascending order")
+	estimate := flag.Bool("estimate-first", false, "Estimate the unique count from the beginning of the file before exact counting")
+	chunkSize := flag.Int("chunk-size", 0, "Split the file into chunks of this many bytes shared by the threads")
+	maxLineBytes := flag.Int("max-line-scan-bytes", BUFFER_SIZE, "Drop lines longer than this many bytes as skipped lines, at most 4MB")
+	plan := flag.Bool("plan", false, "Print how the files would be split between the threads and exit without reading them")
+	ioUringFlag := flag.Bool("iouring", false, "Read the files with io_uring on linux, falls back to the regular reads when it's not available")
+	skipReadErrors := flag.Bool("skip-read-errors", false, "Report a read error which persists after the retries and continue the chunk past the unreadable bytes")
+	shortReads := flag.Bool("retry-on-short-read", false, "Verify the seek of every chunk and fill its first read, retrying reads which return nothing")
+	readRetries := flag.Int("read-retries", 0, "Retry a failed open, seek or read this many times with a growing delay")
+	minThreadBytes := flag.Int("min-thread-bytes", 1<<20, "Minimum number of bytes read by one thread, smaller inputs use fewer threads")
+	failFast := flag.Bool("fail-fast", false, "Stop all workers on the first error")
+	maxErrors := flag.Int("max-errors", 0, "Stop all workers once this many errors are collected")
+	ignoreErrors := flag.Bool("ignore-errors", false, "Exit with status 0 and the partial count even when some reads failed")
+	maxMemoryMb := flag.Int("max-memory", 0, "Stop reading with the partial count before the process uses this many MB")
+	follow := flag.Bool("follow", false, "Keep reading the lines appended to the file until interrupted (like tail -f)")
+	followInterval := flag.Duration("follow-interval", 5*time.Second, "How often the count is printed with -follow")
+	countWindow := flag.Duration("count-window", 0, "With -follow also print the approximate unique count of the last window of time")
+	excludeZero := flag.Bool("exclude-zero", false, "Don't count the placeholder addresses listed by -sentinels")
+	sentinels := &addressList{addrs: []netip.Addr{netip.IPv4Unspecified(), netip.AddrFrom4([4]byte{255, 255, 255, 255})}}
+	flag.Var(sentinels, "sentinels", "Comma separated placeholder addresses excluded by -exclude-zero")
+	parseOnlyFlag := flag.Bool("parse-only", false, "Only parse the lines without counting them to measure the parser throughput")
+	onlyPath := flag.String("only-file", "", "Count only the IP addresses listed in the given file")
+	inFormat := flag.String("in-format", "dotted", "Input line format: dotted, weblog, jsonl or auto")
+	formatAutodetect := flag.Bool("format-autodetect", false, "Detect the input format (dotted, int, hex, jsonl, weblog) from the first 100 non-empty lines")
+	delimiter := &fieldDelimiter{}
+	flag.Var(delimiter, "delimiter", "Separator of the fields of -in-format auto: one character or \\t (Default: whitespace)")
+	jsonKey := flag.String("json-key", "ip", "Key of the IP address field for -in-format jsonl")
+	binaryMode := flag.Bool("binary", false, "The input files are raw 4-byte big-endian IPv4 addresses without newlines")
+	rangesMode := flag.Bool("ranges", false, "Every line is an inclusive range of addresses (10.0.0.0-10.0.0.255), all of them are counted")
+	cidrMode := flag.Bool("cidr", false, "Every line is a network in CIDR notation (10.0.0.0/24), all its addresses are counted")
+	maxRangeSize := flag.Uint64("max-range", 1<<24, "Largest range expanded by -ranges or -cidr, larger ones are skipped")
+	regexMode := flag.Bool("regex", false, "Extract and count every dotted-quad IP address found anywhere in free-form lines")
+	normalize := flag.Bool("normalize-whitespace", true, "Trim the spaces and tabs around the lines before parsing them (\t1.2.3.4)")
+	compatNetip := flag.Bool("compat-netip", false, "Validate the IP addresses exactly like Go's netip.ParseAddr")
+	collisionSample := flag.Int("count-collisions", 0, "Check the first N lines for textually different lines parsed to the same address, a parser diagnostic")
+	multiFormat := flag.Bool("multi-format", false, "Also accept the hex (0x01020304) and integer (16909060) forms of the IP addresses")
+	progressPath := flag.String("progress-json", "", "Write newline-delimited JSON progress events to the given file or fd:N")
+	progressInterval := flag.Duration("progress-interval", time.Second, "How often the -progress-json events are written")
+	watch := flag.Duration("watch", 0, "Print the live unique count to stdout on its own line at this interval, the summary goes to stderr")
+	windowsCsv := flag.String("windows-csv", "", "Write the unique count of every -window-size window of the weblog times to the given CSV file")
+	windowSizeFlag := flag.Duration("window-size", time.Hour, "Length of the time windows of -windows-csv")
+	expect := flag.Int64("expect", -1, "Exit with an error when the unique count differs from the given number")
+	cpuProfile := flag.String("cpuprofile", "", "Write the CPU profile of the count phase to the given file (go tool pprof)")
+	memProfile := flag.String("memprofile", "", "Write the heap profile after the count phase to the given file (go tool pprof)")
+	logLevel := flag.String("log-level", "info", "Diagnostics verbosity: error, info or debug")
+	warmup := flag.Bool("warmup", false, "Touch every page of the bitset before reading")
+	sparse := flag.Bool("sparse", false, "Allocate the bitset lazily per /16 instead of 512MB upfront")
+	approx := flag.Bool("approx", false, "Estimate the unique count with HyperLogLog in 64KB instead of the 512MB bitset")
+	backend := flag.String("backend", "array", "Set implementation: auto, array, sparse, roaring or hashset")
+	dupWindowSize := flag.Int("dup-window", 0, "Count the IPs repeated within this many previous addresses (single thread)")
+	minOccurs := flag.Int("min-occurrences", 0, "Also count the IPs seen at least this many times")
+	networkBits := flag.Int("network-bits", 32, "Count unique networks of the given prefix length instead of hosts")
 
 	flag.Parse()
 
+	if *version {
+		printVersion(os.Stdout)
+		os.Exit(0)
+	}
+
 	if *help || *helpLong {
-		fmt.Println("Usage: program -f <file-path> [-r <ram-in-gb>]")
+		fmt.Println("Usage: program -f <file-path> [flags] [file-path...]")
 		fmt.Println("\nFlags:")
 		fmt.Println("  -h, -help          Display usage information")
-		fmt.Println("  -t, -threads       Set number of threads (Default: Number of CPU logical cores)")
-		fmt.Println("  -f, -file          Path to the input file (mandatory)")
+		fmt.Println("  -version           Print the version, Go version and VCS revision of the build")
+		fmt.Println("  -t, -threads       Set number of threads, or auto (number of CPU logical cores) or max (4 per core, for slow disks")
+		fmt.Println("                     and network filesystems where the reads wait more than they parse) (Default: auto)")
+		fmt.Println("  -f, -file          Path to the input file (mandatory unless -ip is given)")
+		fmt.Println("                     More files can be given as arguments after the flags, they share one pool of threads")
+		fmt.Println("  -count-per-file    Print the unique count of every file and the new unique IPs it added to the previous files")
+		fmt.Println("  -merge-sorted      The files are sorted in ascending order (e.g. -write output), count their union with a k-way merge in O(files) memory")
+		fmt.Println("  -ip                IP address to count, can be repeated")
+		fmt.Println("  -follow            Keep reading the lines appended to the file and print the count periodically until Ctrl+C")
+		fmt.Println("  -follow-interval   How often the count is printed with -follow (Default: 5s)")
+		fmt.Println("  -count-window      With -follow also print the approximate unique count of the last window, e.g. 5m (HyperLogLog, ~1% error)")
+		fmt.Println("  -exclude-zero      Don't count the placeholder addresses listed by -sentinels")
+		fmt.Println("  -sentinels         Comma separated placeholder addresses (Default: 0.0.0.0,255.255.255.255)")
+		fmt.Println("  -only-file         Count only the IP addresses listed in the given file (one per line)")
+		fmt.Println("  -in-format         Input line format: dotted (one IP per line), weblog (IP is the first field), jsonl, auto (detects the whitespace separated IP field) (Default: dotted)")
+		fmt.Println("  -format-autodetect Detect the format of the input from the first 100 non-empty lines of the first file: dotted, int or hex")
+		fmt.Println("                     (read with -multi-format), jsonl (with -json-key) or weblog; the choice is logged, mixed formats are an error")
+		fmt.Println("  -regex             Count every dotted-quad found anywhere in the lines (free-form logs), lines without any are reported")
+		fmt.Println("  -binary            The files hold raw 4-byte big-endian addresses (no newlines), read without any text parsing")
+		fmt.Println("                     the files are split at record boundaries, a partial last record is reported and skipped")
+		fmt.Println("  -ranges            Every line is an inclusive range like 10.0.0.0-10.0.0.255, every address of it is counted")
+		fmt.Println("  -cidr              Every line is a network like 10.0.0.0/24 (or a single address), every address of it is counted")
+		fmt.Println("  -max-range         Largest range expanded by -ranges or -cidr, larger ones are skipped with a warning (Default: 16777216, a /8)")
+		fmt.Println("                     raise it to opt in to the larger networks, e.g. 4294967296 for anything up to 0.0.0.0/0")
+		fmt.Println("  -normalize-whitespace Trim the spaces and tabs around every line, so indented or padded addresses are counted (Default: true)")
+		fmt.Println("                     -normalize-whitespace=false parses the lines as they are, the padded ones are then skipped or misread")
+		fmt.Println("  -compat-netip      Count only the addresses netip.ParseAddr accepts: no leading zeros, empty fields, octets > 255 or junk")
+		fmt.Println("  -multi-format      Also accept the hex (0x01020304) and integer (16909060) forms, all notations of an address count once")
+		fmt.Println("  -delimiter         Separator of the fields of -in-format auto, one character (, | ;) or \\t, every delimiter ends a field")
+		fmt.Println("                     and the blanks around the fields are trimmed, e.g. -delimiter , for CSV (Default: whitespace)")
+		fmt.Println("  -json-key          Key of the IP address field for -in-format jsonl (Default: ip)")
+		fmt.Println("  -write             Write the unique IP addresses to the given file, one per line in ascending order")
+		fmt.Println("  -write-binary      Write the unique IP addresses to the given file as 4-byte big-endian records in ascending order, read back by -binary")
+		fmt.Println("  -write-binary-checksum")
+		fmt.Println("                     Write the -write-binary file as a dump: a header, then the CRC32 after every 65536 records,")
+		fmt.Println("                     so -binary and -baseline reject a corrupted or truncated dump (Default: raw records only)")
+		fmt.Println("  -baseline          Compare with the -write-binary dump of an earlier run, e.g. yesterday's: report the IP addresses")
+		fmt.Println("                     added since (in the input, not in the dump) and removed since (in the dump, not in the input)")
+		fmt.Println("  -baseline-added    Write the added IP addresses to the given file, formatted like -write")
+		fmt.Println("  -baseline-removed  Write the removed IP addresses to the given file, formatted like -write")
+		fmt.Println("  -out-format        Format of the -write output: dotted, int or hex (zero-padded 0x0a000001) (Default: dotted)")
+		fmt.Println("  -o                 Format of the result on stdout: text (one line per number) or json (one object) (Default: text)")
+		fmt.Println("  -human             Follow the unique count of the text summary with its readable forms: 29895434 (29,895,434 / 29.9M)")
+		fmt.Println("  -quiet             Print only the raw unique count (the parsed lines with -parse-only) and nothing else on stdout")
+		fmt.Println("  -export-blocklist  Export the unique IP addresses as a firewall blocklist: dotted IPs or CIDRs, one per line")
+		fmt.Println("  -blocklist-header  Start the exported blocklist with # comments: date, source files and count (Default: true)")
+		fmt.Println("  -shard-output      Write the unique IP addresses to the given directory, one file per /8 (0.txt .. 255.txt) plus manifest.txt")
+		fmt.Println("  -gaps              List the addresses of the CIDR range (e.g. 10.0.0.0/24) which are absent from the input, before the summary")
+		fmt.Println("  -octet-distribution Report how many unique IPs have each value (0-255) in each of the 4 octet positions")
+		fmt.Println("                     e.g. a flat 4th octet with a few 3rd octet values shows sequential /24 sweeps")
+		fmt.Println("  -ipv4-classes      Report how many unique IPs belong to each legacy class: A (0-127), B (128-191), C (192-223), D (224-239), E (240-255)")
+		fmt.Println("  -count-distinct-per-prefix-length Report the distinct networks of every prefix length, /32 (or -network-bits) down to /8,")
+		fmt.Println("                     in one pass over the set: how the count collapses as the networks widen")
+		fmt.Println("  -count-reserved-separately Report how many unique IPs fall into each special-use range of the IANA registry")
+		fmt.Println("                     (this-network, private, shared, loopback, link-local, documentation, multicast, reserved, ...) and how many are public")
+		fmt.Println("  -asn-db            Look up every unique IP in the given MaxMind DB of autonomous systems (GeoLite2-ASN, DB-IP ASN, .mmdb)")
+		fmt.Println("                     and report the systems with the most IPs, the number of systems and the IPs of no system")
+		fmt.Println("  -asn-top           Number of the autonomous systems reported with -asn-db (Default: 10)")
+		fmt.Println("  -geo-db            Look up every unique IP in the given MaxMind DB of countries (GeoLite2-Country, DB-IP country, .mmdb)")
+		fmt.Println("                     and report the IPs of every country, the most first, and the IPs of no country")
+		fmt.Println("  -sorted            Verify the ascending order of the -write output and fail if it's broken")
+		fmt.Println("  -count-unique-and-write-in-one-pass Write every -write address the first time a thread sets its bit, while reading,")
+		fmt.Println("                     so no pass over the set follows; the file is in the order of arrival, NOT sorted (array or sparse backend)")
+		fmt.Println("  -estimate-first    Estimate the unique count from the first 64MB of the file before exact counting")
+		fmt.Println("  -chunk-size        Split the file into chunks of this many bytes processed by the threads (Default: file size / threads)")
+		fmt.Println("  -max-line-scan-bytes Drop the lines longer than this many bytes without buffering them, they are counted as skipped lines,")
+		fmt.Println("                     a guard against input without newlines (Default: 4MB, also the maximum)")
+		fmt.Println("  -plan              Print the work plan and exit without reading: the size and the [start, end) byte range of every chunk,")
+		fmt.Println("                     the reading threads, the read buffers and the size of the set")
+		fmt.Println("  -min-thread-bytes  Minimum bytes per thread, fewer threads are started for smaller files, -t stays the upper bound (Default: 1MB)")
+		fmt.Println("  -network-bits      Count unique networks with the given prefix length (Default: 32)")
+		fmt.Println("  -count-collisions  Parser diagnostic: check the first N lines with an address for different lines parsed to the same")
+		fmt.Println("                     address (e.g. 1.2.3.04 and 1.2.3.4), which reveals the filters of the parser letting bad lines through,")
+		fmt.Println("                     and report them with both lines; plain dotted-quad input only (Default: disabled)")
+		fmt.Println("  -parse-only        Read and parse the lines but discard the IPs, reports the lines/s of reading and parsing alone")
+		fmt.Println("  -warmup            Pre-fault the bitset memory before reading and report the time separately")
+		fmt.Println("  -iouring           Read the files with io_uring (linux 5.6+), keeping 4 reads of 1MB in flight per thread")
+		fmt.Println("                     falls back to the regular reads with a warning when io_uring isn't available, ignores -read-retries")
+		fmt.Println("  -read-retries      Retry a failed open, seek or read of a chunk this many times, waiting 100ms, 200ms, 400ms, ... (Default: 0)")
+		fmt.Println("  -retry-on-short-read For network filesystems: verify the position after the seek of every chunk and keep reading")
+		fmt.Println("                     until the first read is full, reads returning nothing are retried (at least 3 times)")
+		fmt.Println("  -skip-read-errors  When a read still fails after the retries, report the error and continue the chunk from the next line")
+		fmt.Println("                     4KB after the failed position instead of dropping the rest of the chunk (plain text files)")
+		fmt.Println("  -fail-fast         Stop all workers as soon as one of them fails")
+		fmt.Println("  -max-errors        Stop all workers once this many errors are collected (Default: no limit)")
+		fmt.Println("  -ignore-errors     Log the read errors but exit with status 0 and the partial count, instead of status 1")
+		fmt.Println("  -max-memory        Stop reading with an error and the partial count before the process uses this many MB, for the")
+		fmt.Println("                     backends which grow with the input (sparse, roaring, hashset) (Default: no limit)")
+		fmt.Println("  -min-occurrences   Also count the IPs seen at least K times using a 64MB count-min sketch (Default: disabled)")
+		fmt.Println("  -dup-window        Count the IPs which repeat within the last N addresses, reads the files with a single thread in order (Default: disabled)")
+		fmt.Println("  -sparse            Allocate the bitset lazily in 8KB blocks per /16, memory grows with the number of distinct /16s")
+		fmt.Println("  -approx            Estimate the unique count with a 64KB HyperLogLog sketch (~0.8% error) instead of allocating the bitset")
+		fmt.Println("  -backend           Set implementation: array (512MB bitset), sparse (same as -sparse), roaring (sorted arrays or bitmaps per /16),")
+		fmt.Println("                     hashset (maps, for small inputs) or auto (picked from the -estimate-first sample) (Default: array)")
+		fmt.Println("  -progress-json     Write newline-delimited JSON progress events to the given file or file descriptor (fd:3)")
+		fmt.Println("                     {\"bytes\":N,\"total\":T,\"unique\":U,\"elapsed_ms\":M,\"done\":false}, the last event has done=true")
+		fmt.Println("  -progress-interval How often the -progress-json events are written (Default: 1s)")
+		fmt.Println("  -watch             Print the live unique count to stdout on its own line every interval (e.g. 5s), and the exact count")
+		fmt.Println("                     at the end; the summary is printed to stderr instead, so stdout is a plain stream of numbers")
+		fmt.Println("  -windows-csv       Write the unique IPs of every time window to the given CSV file: window_start,window_end,unique")
+		fmt.Println("                     one row per window with lines, in time order; the time is the [10/Oct/2000:13:55:36 -0700] field, needs -in-format weblog")
+		fmt.Println("  -window-size       Length of the -windows-csv windows, aligned to the Unix epoch in UTC (Default: 1h)")
+		fmt.Println("  -expect            Fail with exit status 1 when the unique count differs from the given number, for pipeline checks")
+		fmt.Println("  -cpuprofile        Write the CPU profile of reading and counting to the given file, for go tool pprof")
+		fmt.Println("  -memprofile        Write the heap profile taken after the count to the given file, for go tool pprof")
+		fmt.Println("  -log-level         Verbosity of the diagnostics written to stderr: error, info, debug (Default: info)")
 		os.Exit(0)
 	}
 
-	finalFilePath := *filePath
-	if finalFilePath == "" {
-		finalFilePath = *filePathLong
+	finalFilePaths := []string{}
+	if *filePath != "" {
+		finalFilePaths = append(finalFilePaths, *filePath)
+	} else if *filePathLong != "" {
+		finalFilePaths = append(finalFilePaths, *filePathLong)
+	}
+	finalFilePaths = append(finalFilePaths, flag.Args()...)
+
+	threads := numThreads
+	if threads.value == 0 {
+		threads = numThreadsLong
+	}
+	finalNumThreads := threads.value
+
+	finalBackend, err := resolveBackend(*backend, *sparse, *approx)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	if *maxMemoryMb < 0 {
+		fmt.Println("Error: Max memory must not be negative")
+		os.Exit(1)
+	}
+
+	formatIp, err := ipFormatter(*outFormat)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	parser, err := newLineParser(*inFormat, *jsonKey, delimiter.value, DottedQuadParser{Strict: *compatNetip, MultiFormat: *multiFormat})
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if *regexMode {
+		if *inFormat != "dotted" {
+			fmt.Println("Error: -regex can't be used with -in-format")
+			os.Exit(1)
+		}
+		parser = TextParser{}
+	}
+	if *rangesMode || *cidrMode {
+		if *inFormat != "dotted" || *regexMode || (*rangesMode && *cidrMode) {
+			fmt.Println("Error: -ranges and -cidr can't be used together or with -in-format or -regex")
+			os.Exit(1)
+		}
+		address := DottedQuadParser{Strict: *compatNetip, MultiFormat: *multiFormat}
+		if *cidrMode {
+			parser = CIDRParser{Address: address}
+		} else {
+			parser = RangeParser{Address: address}
+		}
+	}
+	if *binaryMode && (*inFormat != "dotted" || *formatAutodetect || *regexMode || *rangesMode || *cidrMode || *multiFormat || *compatNetip) {
+		fmt.Println("Error: -binary reads no text, it can't be used with -in-format, -format-autodetect, -regex, -ranges, -cidr, -multi-format or -compat-netip")
+		os.Exit(1)
+	}
+
+	excludedIps := []netip.Addr{}
+	if *excludeZero {
+		excludedIps = sentinels.addrs
 	}
-	if finalFilePath == "" {
-		fmt.Println("Error: -f or -file flag is required")
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fmt.Println("Error: Log level must be one of error, info, debug")
 		os.Exit(1)
 	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	if threads.keyword != "" {
+		slog.Info("resolved thread count", "threads", finalNumThreads, "keyword", threads.keyword, "cpus", runtime.NumCPU())
+	}
 
-	finalNumThreads := *numThreads
-	if finalNumThreads == 0 {
-		finalNumThreads = *numThreadsLong
+	// a file given twice (also through a symlink or another relative path) would double the line counts
+	finalFilePaths = dedupeFiles(finalFilePaths)
+
+	useIoUring := *ioUringFlag
+	if useIoUring {
+		if err := ioUringAvailable(); err != nil {
+			slog.Warn("io_uring is not available, the files are read with the regular reads", "err", err)
+			useIoUring = false
+		}
 	}
 
-	if finalNumThreads < 1 {
-		fmt.Println("Error: Thread number must be greater than 0")
+	// after the logger is set up, the detected field or format is logged
+	if *formatAutodetect {
+		if *inFormat != "dotted" || *regexMode || *rangesMode || *cidrMode {
+			fmt.Println("Error: -format-autodetect can't be used with -in-format, -regex, -ranges or -cidr")
+			os.Exit(1)
+		}
+		if len(finalFilePaths) > 0 {
+			parser, err = detectLineFormat(finalFilePaths[0], *jsonKey, DottedQuadParser{Strict: *compatNetip, MultiFormat: *multiFormat})
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+		}
+	}
+	if *inFormat == "auto" && len(finalFilePaths) > 0 {
+		parser, err = detectIpField(finalFilePaths[0], DottedQuadParser{Strict: *compatNetip, MultiFormat: *multiFormat}, delimiter.value)
+		if err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}
+
+	config := Config{
+		filePaths:        finalFilePaths,
+		countPerFile:     *countPerFile,
+		mergeSorted:      *mergeSorted,
+		alsoStdin:        *alsoStdin,
+		addresses:        addresses,
+		onlyPath:         *onlyPath,
+		excluded:         excludedIps,
+		parser:           parser,
+		delimiter:        delimiter.value,
+		binary:           *binaryMode,
+		numThreads:       finalNumThreads,
+		networkBits:      *networkBits,
+		warmup:           *warmup,
+		backend:          finalBackend,
+		minOccurs:        *minOccurs,
+		failFast:         *failFast,
+		estimate:         *estimate,
+		maxErrors:        *maxErrors,
+		maxMemory:        uint64(*maxMemoryMb) << 20,
+		ignoreErrors:     *ignoreErrors,
+		chunkSize:        *chunkSize,
+		maxLineBytes:     *maxLineBytes,
+		plan:             *plan,
+		minThreadBytes:   *minThreadBytes,
+		writePath:        *writePath,
+		onePass:          *onePass,
+		binaryPath:       *writeBinaryPath,
+		binaryChecksum:   *binaryChecksum,
+		baselinePath:     *baselinePath,
+		addedPath:        *addedPath,
+		removedPath:      *removedPath,
+		sorted:           *sorted,
+		formatIp:         formatIp,
+		output:           *output,
+		human:            *human,
+		quiet:            *quiet,
+		cpuProfile:       *cpuProfile,
+		memProfile:       *memProfile,
+		octets:           *octets,
+		classes:          *classes,
+		prefixSweep:      *prefixSweep,
+		specialUse:       *specialUse,
+		asnDbPath:        *asnDbPath,
+		asnTop:           *asnTop,
+		geoDbPath:        *geoDbPath,
+		ioUring:          useIoUring,
+		maxRange:         *maxRangeSize,
+		blocklistPath:    *blocklistPath,
+		blocklistHeader:  *blocklistHeader,
+		shardDir:         *shardDir,
+		gaps:             gaps.prefix,
+		follow:           *follow,
+		followInterval:   *followInterval,
+		countWindow:      *countWindow,
+		parseOnly:        *parseOnlyFlag,
+		collisionSample:  *collisionSample,
+		normalize:        *normalize,
+		expect:           *expect,
+		readRetries:      *readRetries,
+		shortReads:       *shortReads,
+		skipReadErrors:   *skipReadErrors,
+		dupWindow:        *dupWindowSize,
+		windowsCsv:       *windowsCsv,
+		windowSize:       *windowSizeFlag,
+		progressPath:     *progressPath,
+		progressInterval: *progressInterval,
+		watch:            *watch,
+	}
+	if err := validateConfig(config); err != nil {
+		fmt.Println("Error:", err)
 		os.Exit(1)
 	}
+	if config.dupWindow > 0 {
+		// the window needs the lines in the file order
+		config.numThreads = 1
+	}
+	return config
+}
+
+// Function which resolves the -sparse and -approx shorthands into the backend of -backend
+// They are the sparse and the approx backend, so they conflict with any other -backend
+func resolveBackend(backend string, sparse bool, approx bool) (string, error) {
+	switch {
+	case sparse && approx:
+		return "", errors.New("-approx can't be used with -backend or -sparse")
+	case sparse && backend != "array" && backend != "sparse":
+		return "", errors.New("-sparse can't be used with another -backend")
+	case approx && backend != "array":
+		return "", errors.New("-approx can't be used with -backend or -sparse")
+	case sparse:
+		return "sparse", nil
+	case approx:
+		return "approx", nil
+	}
+	return backend, nil
+}
+
+// Function which checks the values and the combinations of the options of the config
+// It returns the first problem as a descriptive error instead of exiting, so the same rules apply
+// to a Config built in code. The flags which only select a value (the formats, -sparse and -approx,
+// see resolveBackend) and the values of the flag.Value types are checked by cli while parsing them
+func validateConfig(config Config) error {
+	switch config.backend {
+	case "auto", "array", "sparse", "roaring", "hashset", "approx":
+	default:
+		return fmt.Errorf("Unknown set backend %q, expected auto, array, sparse, roaring or hashset", config.backend)
+	}
+	if config.output != "text" && config.output != "json" {
+		return fmt.Errorf("Unknown output format %q, expected text or json", config.output)
+	}
+	if config.gaps.IsValid() && !config.gaps.Addr().Is4() {
+		return fmt.Errorf("Invalid IPv4 CIDR range %s for -gaps", config.gaps)
+	}
+	for _, sentinel := range config.excluded {
+		if !sentinel.Is4() {
+			return fmt.Errorf("Invalid sentinel address %s, only IPv4 addresses are counted", sentinel)
+		}
+	}
+	if _, isField := config.parser.(FieldParser); config.delimiter != 0 && !isField {
+		return errors.New("-delimiter requires -in-format auto")
+	}
+
+	switch {
+	case len(config.filePaths) == 0 && len(config.addresses) == 0 && !config.alsoStdin:
+		return errors.New("-f, -file, a file argument, -ip or -also-stdin is required")
+	case config.alsoStdin && (config.follow || config.mergeSorted || config.countPerFile || config.binary || config.plan):
+		return errors.New("-also-stdin can't be used with -follow, -merge-sorted, -count-per-file, -binary or -plan")
+	case config.follow && len(config.filePaths) != 1:
+		return errors.New("-follow requires exactly one file")
+	case config.numThreads < 1:
+		return errors.New("Thread number must be greater than 0")
+	case config.networkBits < 1 || config.networkBits > 32:
+		return errors.New("Network bits must be between 1 and 32")
+	case config.dupWindow < 0:
+		return errors.New("Dup window must not be negative")
+	case config.minOccurs < 0:
+		return errors.New("Min occurrences must not be negative")
+	case config.follow && config.followInterval <= 0:
+		return errors.New("Follow interval must be positive")
+	case config.countWindow < 0 || (config.countWindow > 0 && !config.follow):
+		return errors.New("-count-window requires -follow and a positive duration")
+	case config.progressPath != "" && config.progressInterval <= 0:
+		return errors.New("Progress interval must be positive")
+	case config.progressPath != "" && config.follow:
+		return errors.New("-progress-json can't be used with -follow")
+	case config.watch < 0:
+		return errors.New("Watch interval must not be negative")
+	case config.watch > 0 && (config.follow || config.mergeSorted || config.parseOnly):
+		return errors.New("-watch can't be used with -follow, which prints the count itself, -merge-sorted or -parse-only")
+	case config.expect < -1:
+		return errors.New("Expected count must not be negative")
+	case config.readRetries < 0:
+		return errors.New("Read retries must not be negative")
+	case config.maxErrors < 0:
+		return errors.New("Max errors must not be negative")
+	case config.minThreadBytes < 0:
+		return errors.New("Min thread bytes must not be negative")
+	case config.chunkSize < 0:
+		return errors.New("Chunk size must not be negative")
+	case config.maxRange < 1:
+		return errors.New("Max range must be at least 1")
+	case config.maxLineBytes < 1 || config.maxLineBytes > BUFFER_SIZE:
+		return fmt.Errorf("Max line scan bytes must be between 1 and %d", BUFFER_SIZE)
+	case config.asnTop < 1:
+		return errors.New("ASN top must be at least 1")
+	case config.collisionSample < 0:
+		return errors.New("Collision sample must not be negative")
+	}
+
+	outputs := config.writePath != "" || config.binaryPath != "" || config.blocklistPath != "" || config.shardDir != "" ||
+		config.gaps.IsValid() || config.octets || config.classes || config.specialUse || config.prefixSweep || config.baselinePath != "" ||
+		config.asnDbPath != "" || config.geoDbPath != ""
+	switch {
+	case config.mergeSorted && (len(config.addresses) > 0 || config.follow || config.countPerFile || config.minOccurs > 0 || outputs):
+		return errors.New("-merge-sorted counts without the bitset, it can't be used with -ip, -follow, -count-per-file, -min-occurrences or the outputs")
+	case config.backend == "approx" && (outputs || config.mergeSorted || config.expect >= 0):
+		return errors.New("-approx can't be used with -merge-sorted, -expect or the outputs of the addresses")
+	case config.warmup && config.backend != "array":
+		return errors.New("-warmup requires the array backend")
+	case config.output == "json" && (config.human || config.quiet):
+		return errors.New("-human and -quiet format the text summary, they can't be used with -o json")
+	case config.sorted && config.writePath == "":
+		return errors.New("-sorted requires -write")
+	case config.binaryChecksum && config.binaryPath == "":
+		return errors.New("-write-binary-checksum requires -write-binary")
+	case config.onePass && (config.writePath == "" || config.sorted || config.mergeSorted || config.parseOnly):
+		return errors.New("-count-unique-and-write-in-one-pass requires -write and can't be used with -sorted, -merge-sorted or -parse-only")
+	case config.onePass && config.backend != "array" && config.backend != "sparse":
+		return errors.New("-count-unique-and-write-in-one-pass needs the atomic bits of the array or sparse backend")
+	case (config.addedPath != "" || config.removedPath != "") && config.baselinePath == "":
+		return errors.New("-baseline-added and -baseline-removed require -baseline")
+	case config.parseOnly && (outputs || config.follow):
+		return errors.New("-parse-only can't be used with -write, -export-blocklist, -shard-output, -gaps, -octet-distribution, -ipv4-classes, -count-reserved-separately, -count-distinct-per-prefix-length, -baseline, -asn-db, -geo-db or -follow")
+	case config.collisionSample > 0 && (!isPlainDotted(config.parser) || config.binary || config.mergeSorted):
+		return errors.New("-count-collisions checks the plain dotted-quad lines, it can't be used with -in-format, -regex, -ranges, -cidr, -multi-format, -binary or -merge-sorted")
+	case config.plan && (len(config.filePaths) == 0 || config.follow || config.mergeSorted):
+		return errors.New("-plan needs input files and can't be used with -follow or -merge-sorted, which don't split them")
+	case config.binary && (config.follow || config.mergeSorted || config.estimate || config.backend == "auto"):
+		return errors.New("-binary can't be used with -follow, -merge-sorted, -estimate-first or -backend auto")
+	}
+
+	// the array is allocated in full before reading, a smaller budget would stop the count right away
+	if arrayBytes := uint64(bitsetWords(config.networkBits)) * 4; config.maxMemory > 0 && config.backend == "array" && arrayBytes >= config.maxMemory {
+		return fmt.Errorf("-max-memory %d MB is below the %d MB array bitset, use -backend sparse, roaring or hashset", config.maxMemory>>20, arrayBytes>>20)
+	}
 
-	return Config{
-		filePath:   finalFilePath,
-		numThreads: finalNumThreads,
+	if config.windowsCsv != "" {
+		if _, ok := config.parser.(TimeLineParser); !ok {
+			return errors.New("-windows-csv needs the line times of -in-format weblog")
+		}
+		if config.mergeSorted || config.parseOnly || config.backend == "approx" {
+			return errors.New("-windows-csv can't be used with -merge-sorted, -parse-only or -approx")
+		}
+		if config.windowSize < time.Second {
+			return errors.New("Window size must be at least 1s")
+		}
 	}
+	return nil
 }
 
 // Function which calculates the array index and bit index for the given IP address
@@ -83,62 +823,451 @@ func writeIpToUint32Arr(arr []uint32, ip uint32) {
 	atomic.OrUint32(&arr[arrIdx], ipBit)
 }
 
+// Function which touches every memory page of the array so the OS maps it before the read phase
+// Otherwise the first write into each page causes a page fault while the workers are busy reading
+func warmupUint32Arr(arr []uint32) {
+	step := os.Getpagesize() / 4
+	for i := 0; i < len(arr); i += step {
+		arr[i] = 0
+	}
+}
+
+// Function which parses the line and writes the network part of the IP address to the array
+// Returns false when the parser doesn't find an IP address in the line
+// With a MultiLineParser (-regex) every address of the line is added
+func processLine(parser LineParser, line []byte, networkShift uint) bool {
+	// checked inline, trimBlanks is called only for the lines which start or end with a blank
+	if normalizeWhitespace && len(line) > 0 && (line[0] <= ' ' || line[len(line)-1] <= ' ') {
+		line = ipcount.TrimBlanks(line)
+	}
+	if rangeParser != nil {
+		first, last, ok := rangeParser.ParseRange(line)
+		return ok && addRange(first, last, networkShift)
+	}
+	if multiParser != nil {
+		found := false
+		for {
+			ipUint32, rest, ok := multiParser.ParseNext(line)
+			if !ok {
+				return found
+			}
+			addIp(ipUint32, networkShift)
+			found = true
+			line = rest
+		}
+	}
+
+	ipUint32, ok := parser.Parse(line)
+	if !ok {
+		return false
+	}
+	if collisions != nil {
+		collisions.check(line, ipUint32)
+	}
+	if addIp(ipUint32, networkShift) && timeWindows != nil {
+		addToWindow(line, ipUint32>>networkShift)
+	}
+	return true
+}
+
+// Function which writes the network part of the parsed IP address to the set and the optional counters
+// With -parse-only the address is discarded, which isolates the parsing cost from the set updates
+func addIp(ipUint32 uint32, networkShift uint) bool {
+	if parseOnly {
+		return false
+	}
+
+	if len(excluded) > 0 && slices.Contains(excluded, ipUint32) {
+		return false
+	}
+	if allowed != nil && !allowed.Contains(ipUint32>>networkShift) {
+		return false
+	}
+
+	if arrival != nil {
+		if newIps.AddNew(ipUint32 >> networkShift) {
+			arrival.write(ipUint32 >> networkShift)
+		}
+	} else {
+		ips.Add(ipUint32 >> networkShift)
+	}
+	if fileIps != nil {
+		fileIps.Add(ipUint32 >> networkShift)
+	}
+	if occurrences != nil {
+		occurrences.add(ipUint32 >> networkShift)
+	}
+	if duplicates != nil {
+		duplicates.add(ipUint32 >> networkShift)
+	}
+	if rolling != nil {
+		rolling.add(ipUint32>>networkShift, time.Now())
+	}
+	return true
+}
+
 // Function which calculates the number of unique IP addresses in the given array
-// It uses the bits.OnesCount32 function to count the number of set bits in each uint32 element
-// bits.OnesCount32 faster than the loop implementation because it uses the POPCNT instruction
-func calculateUniqueIpsUint32(arr []uint32) uint32 {
-	var count uint32 = 0
-	for _, b := range arr {
-		count += uint32(bits.OnesCount32(b))
+// It uses the bits.OnesCount64 function to count the number of set bits of two uint32 elements at once
+// bits.OnesCount64 faster than the loop implementation because it uses the POPCNT instruction
+// Zero words aren't branched over, the popcount of 0 costs less than the mispredicted branches of
+// the medium dense arrays (see the README benchmark)
+func calculateUniqueIpsUint32(arr []uint32) uint64 {
+	var count uint64 = 0
+	wide, tail := wideWords(arr)
+	for _, w := range wide {
+		count += uint64(bits.OnesCount64(w))
+	}
+	for _, b := range tail {
+		count += uint64(bits.OnesCount32(b))
 	}
 	return count
 }
 
+// Function which calls fn with the index of every set bit in the given array
+// Indexes are visited in ascending order because the bit index encodes the IP value
+// Zero words are skipped as a whole, 64 bits at a time, so sparse arrays are iterated quickly
+func forEachIpUint32Arr(arr []uint32, fn func(ip uint32)) {
+	wide, tail := wideWords(arr)
+	for wordIdx, w := range wide {
+		for w != 0 {
+			bitIdx := bits.TrailingZeros64(w)
+			w &= w - 1
+			fn(uint32(wordIdx)<<6 | uint32(bitIdx))
+		}
+	}
+	base := uint32(len(arr) - len(tail))
+	for arrIdx, b := range tail {
+		for b != 0 {
+			bitIdx := bits.TrailingZeros32(b)
+			b &= b - 1
+			fn((base+uint32(arrIdx))<<5 | uint32(bitIdx))
+		}
+	}
+}
+
+// Function which views the array as uint64 words on the little-endian CPUs, where the word k holds the
+// elements 2k (low half) and 2k+1 (high half), so its bit i is the bit index 64k+i of the array
+// Returns the words and the elements after them (the odd last one), or no words and the whole array
+// on a big-endian CPU
+func wideWords(arr []uint32) ([]uint64, []uint32) {
+	if !littleEndian || len(arr) < 2 {
+		return nil, arr
+	}
+	return unsafe.Slice((*uint64)(unsafe.Pointer(&arr[0])), len(arr)/2), arr[len(arr)&^1:]
+}
+
+// Function which calculates the number of uint32 words needed to store one bit per network
+// For the full /32 this is 2^27 words, every removed bit halves the array (minimum one word)
+func bitsetWords(networkBits int) int {
+	return max(1, POW2_27>>(32-networkBits))
+}
+
+// Split function state for the bufio.Scanner which works as bufio.ScanLines
+// but drops lines longer than maxLineBytes (or the scanner buffer) instead of failing with bufio.ErrTooLong
+type lineSplitter struct {
+	maxLineBytes  int             // Longest line returned, longer ones are dropped (0 = the scanner buffer)
+	stream        *trackingReader // Reader of the scanner, the line in which its read failed is dropped (nil = not tracked)
+	rangeEnd      int             // Dropped lines which start at or after it belong to the next chunk and aren't counted (0 = no end)
+	skipping      bool            // Currently dropping an overlong line until the next newline
+	countDropped  bool            // The line being dropped started before rangeEnd
+	skippedLines  int             // Number of dropped overlong lines
+	consumedBytes int             // Number of bytes consumed from the stream including the line endings and the dropped lines
+	lineStart     int             // Position of the last returned line in the stream
+}
+
+// Function which returns the next line from data and counts the consumed bytes
+// The count is the real position in the stream, the token alone can't tell it because
+// ScanLines strips the \r of CRLF endings and the last line may have no newline at all
+// The dropped lines are consumed in the same call as the line after them, the scanner stops
+// after EOF as soon as a call returns no token, which would lose the lines after a dropped one
+func (s *lineSplitter) split(data []byte, atEOF bool) (int, []byte, error) {
+	consumed := 0
+	for {
+		advance, token, err := s.next(data[consumed:], atEOF)
+		if token != nil {
+			s.lineStart = s.consumedBytes
+		}
+		s.consumedBytes += advance
+		consumed += advance
+		if token != nil || err != nil || advance == 0 || consumed == len(data) {
+			return consumed, token, err
+		}
+	}
+}
+
+// Function which returns the next line from data
+// A line longer than the limit is consumed without a token, and when the data ends before its newline
+// the rest of the line is consumed the same way until the next newline
+func (s *lineSplitter) next(data []byte, atEOF bool) (int, []byte, error) {
+	if s.skipping {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			s.skipping = false
+			s.dropped()
+			return i + 1, nil, nil
+		}
+		if atEOF {
+			s.skipping = false
+			s.dropped()
+		}
+		return len(data), nil, nil
+	}
+
+	limit := BUFFER_SIZE
+	if s.maxLineBytes > 0 {
+		limit = min(s.maxLineBytes, BUFFER_SIZE)
+	}
+	// data starts with a new line, so consumedBytes is its position in the stream; the calls which
+	// continue an overlong line keep the decision made where the line starts
+	s.countDropped = s.rangeEnd == 0 || s.consumedBytes < s.rangeEnd
+	// the read failed inside the last line, the part read before the failure is not the whole line
+	if atEOF && s.stream != nil && s.stream.err != nil && len(data) > 0 && bytes.IndexByte(data, '\n') < 0 {
+		s.dropped()
+		return len(data), nil, nil
+	}
+
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if token != nil && len(token) > limit {
+		s.dropped()
+		return advance, nil, nil
+	}
+	// an unterminated line is known to be too long once it has more bytes than the limit or fills the buffer
+	if advance == 0 && token == nil && (len(data) > limit || len(data) >= BUFFER_SIZE) {
+		s.skipping = true
+		return len(data), nil, nil
+	}
+	return advance, token, err
+}
+
+// Function which counts the dropped line unless it belongs to the next chunk
+func (s *lineSplitter) dropped() {
+	if s.countDropped {
+		s.skippedLines++
+	}
+}
+
+// Function which returns the opener of the file for the chunk readers, io_uring or the retrying reader
+func chunkOpener(config Config, path string) func(pos int64) (io.ReadCloser, error) {
+	return func(pos int64) (io.ReadCloser, error) {
+		if config.ioUring {
+			return openIoUringReader(path, pos)
+		}
+		return openRetryReader(path, pos, config.readRetries, config.shortReads)
+	}
+}
+
 // Function which read the specific part/size of the file and extract the IP addresses
-// Converts byte line to uint32 IP address and writes it to the array using writeIpToUint32Arr function
-func fileRead(name string, offset int64, bytesPerThread int, errCh chan<- error) {
-	file, err := os.Open(name)
+// Processes the lines which start in [offset, offset+length), the line which begins before
+// the offset belongs to the previous chunk and the last line may end after the range
+// Converts byte line to uint32 IP address, keeps only the network part of it
+// and writes it to the array using writeIpToUint32Arr function
+// With skipReadErrors a read error which persists after the retries is reported and the chunk goes on
+// READ_SKIP_BYTES after the failed position, from the next line like a chunk starting there
+// Stops early when the context is cancelled, every error (nil when the range was read) is passed to report
+func fileRead(ctx context.Context, config Config, path string, open func(pos int64) (io.ReadCloser, error), offset int64, length int, report func(error)) {
+	end := offset + int64(length)
+	for {
+		err := readRange(ctx, config, open, offset, int(end-offset))
+		var readErr *streamError
+		if err == nil || !config.skipReadErrors || !errors.As(err, &readErr) || ctx.Err() != nil {
+			report(err)
+			break
+		}
+		failedAt := offset + readErr.offset
+		offset = min(failedAt+READ_SKIP_BYTES, end)
+		slog.Warn("read failed, skipping to the next line after the unreadable bytes", "file", path, "position", failedAt, "resume", offset, "err", readErr.err)
+		report(fmt.Errorf("skipped bytes %d-%d of %s after a read error: %w", failedAt, offset, path, readErr.err))
+		if offset == end {
+			break
+		}
+	}
+	slog.Debug("chunk finished", "file", path, "offset", offset, "length", length)
+}
 
+// Function which processes the lines which start in [offset, offset+length) of the file, see fileRead
+// A failed read of the file is returned as a *streamError with the position relative to the offset
+func readRange(ctx context.Context, config Config, open func(pos int64) (io.ReadCloser, error), offset int64, length int) error {
+	// start one byte earlier, if it's a newline the line at the offset is a whole line
+	file, err := open(max(0, offset-1))
 	if err != nil {
-		errCh <- err
-		return
+		return err
 	}
 	defer file.Close()
 
-	_, err = file.Seek(offset, io.SeekStart)
-	if err != nil {
-		errCh <- err
-		return
+	reader := readerPool.Get().(*bufio.Reader)
+	reader.Reset(file)
+	defer func() {
+		reader.Reset(nil) // drops the buffered bytes and the reference to the closed file
+		readerPool.Put(reader)
+	}()
+
+	// bytes from the offset to the start of the first line of the chunk
+	readBytes := 0
+	if offset != 0 {
+		// skip partial read up to the first newline, the line may be longer than the buffer
+		readBytes = -1
+		for {
+			var part []byte
+			part, err = reader.ReadSlice('\n')
+			readBytes += len(part)
+			if err != bufio.ErrBufferFull {
+				break
+			}
+		}
+		if err != nil && err != io.EOF {
+			return &streamError{offset: int64(max(0, readBytes)), err: err}
+		}
+		// no line starts inside the range (more threads than lines), the next line belongs to the next chunk
+		if readBytes >= length {
+			slog.Debug("chunk has no line", "offset", offset, "length", length)
+			return nil
+		}
 	}
 
-	scanner := bufio.NewScanner(bufio.NewReaderSize(file, BUFFER_SIZE))
-	scanner.Buffer(make([]byte, BUFFER_SIZE), BUFFER_SIZE)
+	return scanLines(ctx, config, reader, readBytes, length, &processedBytes)
+}
 
-	if offset != 0 {
-		// one extra scan to skip partial read from offset
-		scanner.Scan()
+// Read error of a stream with the position where the data ends, relative to the start of the range
+// It reads like the error it wraps, so the errors of the chunks are still deduplicated by the message
+type streamError struct {
+	offset int64
+	err    error
+}
+
+func (e *streamError) Error() string {
+	return e.err.Error()
+}
+
+func (e *streamError) Unwrap() error {
+	return e.err
+}
+
+// Reader which counts the bytes it returned and keeps the error (other than EOF) which ended the stream
+type trackingReader struct {
+	reader io.Reader
+	read   int
+	err    error
+}
+
+func (r *trackingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += n
+	if err != nil && err != io.EOF {
+		r.err = err
 	}
+	return n, err
+}
 
-	readBytes := 0
-	for scanner.Scan() && readBytes < bytesPerThread {
+// Function which reads the lines from the reader and processes them with processLine
+// readBytes is the position of the first line relative to the start of the range, the lines
+// are read while they start before length (math.MaxInt reads the whole stream)
+// The bytes of the lines are added to progress unless it's nil
+// Stops early when the context is cancelled
+func scanLines(ctx context.Context, config Config, reader io.Reader, readBytes int, length int, progress *atomic.Uint64) (err error) {
+	// a new Scanner starts with an empty window of the buffer, so the stale bytes of a previous
+	// chunk are never returned and the buffer doesn't need clearing
+	buffer := scanBufferPool.Get().(*[]byte)
+	defer scanBufferPool.Put(buffer) // deferred first, so the panic message below is built before the buffer is reused
 
-		bytesLine := scanner.Bytes()
-		readBytes += len(bytesLine) + 1
-		lineLength := len(bytesLine)
+	// a parser bug fails only the range being read, the other workers keep counting
+	var bytesLine []byte
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Debug("line processing panicked", "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic while processing line %q: %v", bytesLine, r)
+		}
+	}()
 
-		if lineLength < 7 || lineLength > 16 {
-			continue
+	stream := &trackingReader{reader: reader}
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(*buffer, BUFFER_SIZE)
+	splitter := lineSplitter{maxLineBytes: config.maxLineBytes, stream: stream}
+	if length != math.MaxInt {
+		splitter.rangeEnd = length - readBytes
+	}
+	scanner.Split(splitter.split)
+
+	networkShift := uint(32 - config.networkBits)
+
+	skipped := 0
+	lines := 0
+	reported := 0
+	// Scan returns with a line, never in the middle of a dropped one, so the bound is checked between
+	// the lines and a dropped line which starts in the range is always finished (and counted) here
+	for readBytes+splitter.consumedBytes < length && scanner.Scan() {
+		lines++
+		if lines%CANCEL_CHECK == 0 {
+			if ctx.Err() != nil {
+				break
+			}
+			if progress != nil {
+				progress.Add(uint64(splitter.consumedBytes - reported))
+				reported = splitter.consumedBytes
+			}
+		}
+
+		// Scan doesn't return after a dropped overlong line, so the line after it may start past the range
+		if readBytes+splitter.lineStart >= length {
+			lines--
+			break
 		}
 
-		ipUint32 := bytesLineToUint32(bytesLine)
-		writeIpToUint32Arr(ips, ipUint32)
+		bytesLine = scanner.Bytes()
+		if !processLine(config.parser, bytesLine, networkShift) {
+			skipped++
+		}
+	}
+	skippedLines.Add(uint64(skipped + splitter.skippedLines))
+	totalLines.Add(uint64(lines + splitter.skippedLines))
+	if progress != nil {
+		progress.Add(uint64(splitter.consumedBytes - reported))
 	}
 
-	if err := scanner.Err(); err != nil {
-		errCh <- err
+	if stream.err != nil {
+		return &streamError{offset: int64(readBytes + stream.read), err: stream.err}
+	}
+	return scanner.Err()
+}
+
+// Function which checks that the file can be opened and read, e.g. it's not a directory
+// and the permissions allow reading it
+func checkReadable(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		return err
 	}
+	return nil
+}
 
-	errCh <- nil
+// Function which drops the paths which point to a file already given before, compared by the device
+// and inode (os.SameFile), so symlinks, hard links and different spellings of the path are caught
+// The set isn't changed by a duplicate, but the line, skipped and per file counts would be
+func dedupeFiles(paths []string) []string {
+	unique := []string{}
+	infos := []os.FileInfo{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			// kept for checkReadable in processIPFile to report
+			unique = append(unique, path)
+			infos = append(infos, nil)
+			continue
+		}
+		duplicateOf := slices.IndexFunc(infos, func(other os.FileInfo) bool {
+			return other != nil && os.SameFile(other, info)
+		})
+		if duplicateOf >= 0 {
+			slog.Warn("input file given more than once, it's read only once", "file", path, "same_as", unique[duplicateOf])
+			continue
+		}
+		unique = append(unique, path)
+		infos = append(infos, info)
+	}
+	return unique
 }
 
 // FUnction which provide the file size in bytes
@@ -152,78 +1281,752 @@ func getFileSize(name string) (int64, error) {
 }
 
 // Function which converts the byte line to uint32 IP address
+// The line is not validated (see ipcount.ParseDottedQuad), malformed lines give some address,
+// everything after the fourth segment is ignored so a line with more dots can't index past the segments
 func bytesLineToUint32(bytes []byte) uint32 {
 	segments := [4]byte{}
 	idx := 0
 	for _, b := range bytes {
 		if b != '.' {
 			segments[idx] = segments[idx]*10 + byte(b-'0')
-		} else {
-			idx++
+		} else if idx++; idx == len(segments) {
+			break
 		}
 	}
 	return uint32(segments[0])<<24 | uint32(segments[1])<<16 | uint32(segments[2])<<8 | uint32(segments[3])
 }
 
-// Worker which servres for the reading specific part of the file
-// It reads from the offset to the offset+bytesPerThread+BYTES_OVERLAP bytes
-// Using Overlap to prevent the loss of the IP addresses which are in the middle of the threads
-func readWorker(id int, wg *sync.WaitGroup, name string, bytesPerThread int, errCh chan<- error) {
+// Function which converts the well-formed byte line to uint32 IP address without the per-byte loop
+// Every segment is read as up to three digits with the dots at fixed places relative to it,
+// so there is no data dependent branch on each byte. Lines of any other shape (empty segments,
+// more than 3 digits, more than 4 segments) fall back to bytesLineToUint32, which also keeps
+// the results of both functions identical for every input
+func bytesLineToUint32Fast(line []byte) uint32 {
+	var ip uint32
+	pos, n := 0, len(line)
+	for segment := 0; segment < 4; segment++ {
+		if pos >= n || line[pos] == '.' {
+			return bytesLineToUint32(line)
+		}
+		value := uint32(line[pos] - '0')
+		pos++
+		if pos < n && line[pos] != '.' {
+			value = value*10 + uint32(line[pos]-'0')
+			pos++
+			if pos < n && line[pos] != '.' {
+				value = value*10 + uint32(line[pos]-'0')
+				pos++
+			}
+		}
+		if segment < 3 {
+			if pos >= n || line[pos] != '.' {
+				return bytesLineToUint32(line)
+			}
+			pos++
+		}
+		ip = ip<<8 | value&255
+	}
+	if pos != n {
+		return bytesLineToUint32(line)
+	}
+	return ip
+}
+
+// Function which calculates the byte range of the chunk
+// Chunk id owns every line which starts in [id*bytesPerChunk, (id+1)*bytesPerChunk),
+// so each line is processed by exactly one chunk even when it's cut by the chunk border
+// Returns the offset and the length of the range
+func chunkRange(id int, bytesPerChunk int) (int64, int) {
+	return int64(id) * int64(bytesPerChunk), bytesPerChunk
+}
+
+// Part of an input file processed by one worker
+type chunkJob struct {
+	path        string // Path to the input file
+	offset      int64  // Start of the byte range owned by the job
+	length      int    // Length of the byte range
+	compression string // Compression of the file, compressed files are one job read as a whole
+	dump        bool   // The range holds whole checksummed frames of a -write-binary dump
+	records     uint64 // Records of the frames of the range of a dump
+	index       int    // Position of the job among the jobs of the run, orders the errors
+}
+
+// Error of a job sent to the collector of readFileChunks, tagged with the index of the job
+type chunkError struct {
+	index int
+	err   error
+}
+
+// Input file with the properties needed to split it into jobs
+type inputFile struct {
+	path        string // Path to the input file
+	size        int64  // Size of the file in bytes
+	compression string // Detected compression, empty for plain files
+	dump        bool   // The plain -binary file is a -write-binary dump with a header and checksums
+	records     uint64 // Records of the dump
+}
+
+// Function which collects the sizes and the compression of the input files
+// With binary the plain files are checked for the header of a -write-binary dump
+func inputFiles(paths []string, binary bool) ([]inputFile, error) {
+	files := []inputFile{}
+	for _, path := range paths {
+		fileSize, err := getFileSize(path)
+		if err != nil {
+			return nil, err
+		}
+		compression, err := detectCompression(path)
+		if err != nil {
+			return nil, err
+		}
+		file := inputFile{path: path, size: fileSize, compression: compression}
+		if binary && compression == "" {
+			if file.records, file.dump, err = readDumpHeader(path, fileSize); err != nil {
+				return nil, err
+			}
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// Worker which servres for the reading chunks of the files received from the jobs channel
+// The same workers are shared by all input files, so many small files don't respawn the goroutines
+func readWorker(ctx context.Context, wg *sync.WaitGroup, config Config, jobs <-chan chunkJob, errCh chan<- chunkError) {
 	defer wg.Done()
-	fileRead(name, int64(max(0, id*bytesPerThread-BYTES_OVERLAP)), bytesPerThread+BYTES_OVERLAP, errCh)
+	for job := range jobs {
+		readJob(ctx, config, job, errCh)
+	}
 }
 
-// Function which start the reading threads
-// It divides the file into the number of threads and starts the reading threads
-// Number of threads is equal to the number of CPU cores or the number of threads provided by the user
-func processIPFile(config Config) (uint32, []error) {
-	threadCount := config.numThreads
-	fileSize, err := getFileSize(config.filePath)
+// Function which reads one job of a worker
+// A panic is sent as the error of the job instead of crashing the process, the worker continues with the next job
+func readJob(ctx context.Context, config Config, job chunkJob, errCh chan<- chunkError) {
+	report := func(err error) {
+		errCh <- chunkError{index: job.index, err: err}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			report(fmt.Errorf("reading %s at offset %d panicked: %v", job.path, job.offset, r))
+		}
+	}()
+	if config.binary {
+		if job.compression != "" {
+			report(readCompressedBinaryFile(ctx, config, job.path, job.compression))
+		} else {
+			report(binaryFileRead(ctx, config, job))
+		}
+		return
+	}
+	if job.compression != "" {
+		report(readCompressedFile(ctx, config, job.path, job.compression))
+		return
+	}
+	fileRead(ctx, config, job.path, chunkOpener(config, job.path), job.offset, job.length, report)
+}
+
+// Function which reads the file with one dotted-quad IP address per line into the set
+// Used for the small auxiliary lists, so it reads the file sequentially
+func readIpList(name string, networkShift uint, set Set) error {
+	file, err := os.Open(name)
 	if err != nil {
-		return 1, []error{err}
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, BUFFER_SIZE), BUFFER_SIZE)
+	splitter := lineSplitter{}
+	scanner.Split(splitter.split)
+
+	for scanner.Scan() {
+		if ipUint32, ok := (DottedQuadParser{}).Parse(ipcount.TrimBlanks(scanner.Bytes())); ok {
+			set.Add(ipUint32 >> networkShift)
+		}
+	}
+	return scanner.Err()
+}
+
+// Function which estimates the number of unique networks in the file from a sample of its beginning
+// The distinct count of the sample is approximated with HyperLogLog and extrapolated to the file size
+// assuming new addresses keep appearing at the same rate, so it's an upper bound rather than a precise value
+func estimateUniqueIps(config Config, path string, fileSize int64) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(io.LimitReader(file, ESTIMATE_SAMPLE_BYTES))
+	scanner.Buffer(make([]byte, BUFFER_SIZE), BUFFER_SIZE)
+	splitter := lineSplitter{}
+	scanner.Split(splitter.split)
+
+	networkShift := uint(32 - config.networkBits)
+	sketch := newHyperLogLog()
+	for scanner.Scan() {
+		if ipUint32, ok := config.parser.Parse(scanner.Bytes()); ok {
+			sketch.add(ipUint32 >> networkShift)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	sampleUnique := sketch.count()
+	sampleBytes := splitter.consumedBytes
+	if sampleBytes == 0 || int64(sampleBytes) >= fileSize {
+		return sampleUnique, nil
+	}
+	estimate := float64(sampleUnique) * float64(fileSize) / float64(sampleBytes)
+	return min(uint64(estimate), uint64(1)<<config.networkBits), nil
+}
+
+// Function which divides the files into the jobs of the reading threads
+// By default there is one chunk per thread in every file, but a chunk is at least minThreadBytes long,
+// so small files get fewer chunks. With chunkSize the files are split into chunks of exactly that size
+// Compressed files can't be split, so each of them is a single job
+func splitJobs(config Config, files []inputFile) []chunkJob {
+	jobs := []chunkJob{}
+	for _, file := range files {
+		if file.compression != "" {
+			// compressed stream can't be split at arbitrary offsets, so it's read by one thread
+			slog.Info("compressed input is read by a single thread", "file", file.path, "compression", file.compression)
+			jobs = append(jobs, chunkJob{path: file.path, compression: file.compression})
+			continue
+		}
+		if file.size == 0 {
+			continue
+		}
+		if file.dump {
+			jobs = append(jobs, splitDump(config, file)...)
+			continue
+		}
+
+		// Rounded up so the chunks cover the whole file, otherwise the lines in the remainder
+		// of the division after the end of the last chunk are lost
+		bytesPerChunk := max(int((file.size+int64(config.numThreads)-1)/int64(config.numThreads)), config.minThreadBytes)
+		if config.chunkSize > 0 {
+			bytesPerChunk = config.chunkSize
+		}
+		if config.binary {
+			// the records must not be cut by the chunk borders, rounded up to the next whole record
+			bytesPerChunk = (bytesPerChunk + BINARY_RECORD_SIZE - 1) / BINARY_RECORD_SIZE * BINARY_RECORD_SIZE
+		}
+		chunkCount := int((file.size + int64(bytesPerChunk) - 1) / int64(bytesPerChunk))
+		for i := 0; i < chunkCount; i++ {
+			offset, length := chunkRange(i, bytesPerChunk)
+			jobs = append(jobs, chunkJob{path: file.path, offset: offset, length: length})
+		}
 	}
+	return jobs
+}
 
-	errCh := make(chan error)
+// Function which start the reading threads
+// It feeds the chunks of all files (see splitJobs) to one pool of reading threads shared by all files
+// Number of threads is equal to the number of CPU cores or the number of threads provided by the user,
+// but never more than the number of chunks, so small inputs don't start idle threads
+// With failFast the first error cancels the remaining workers, with maxErrors the N-th one does
+// The errors are returned in the order of the chunks whatever order the workers hit them in,
+// the errors of one chunk in the order they happened
+func readFileChunks(ctx context.Context, config Config, files []inputFile) []error {
+	chunks := splitJobs(config, files)
+	threadCount := max(1, min(config.numThreads, len(chunks)))
+	if threadCount < config.numThreads {
+		slog.Debug("thread count reduced to the number of chunks", "threads", threadCount, "chunks", len(chunks))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errCh := make(chan chunkError)
 	errDone := make(chan struct{})
-	errs := []error{}
+	chunkErrs := []chunkError{}
 	wg := sync.WaitGroup{}
 
-	bytesPerThread := int(fileSize / int64(threadCount))
+	jobs := make(chan chunkJob)
 
+	// the collector is the only goroutine which touches chunkErrs, it ends when errCh is closed after all
+	// workers returned, and errDone orders its last append before chunkErrs is read below
 	go func() {
 		for err := range errCh {
-			if err != nil {
-				errs = append(errs, err)
+			if err.err != nil {
+				chunkErrs = append(chunkErrs, err)
+				if config.failFast || (config.maxErrors > 0 && len(chunkErrs) >= config.maxErrors) {
+					cancel()
+				}
 			}
 		}
 		errDone <- struct{}{}
 	}()
 
+	stopMemoryWatch := func() error { return nil }
+	if config.maxMemory > 0 {
+		stopMemoryWatch = watchMemory(ctx, config.maxMemory, cancel)
+	}
+
 	for i := 0; i < threadCount; i++ {
 		wg.Add(1)
-		go readWorker(i, &wg, config.filePath, bytesPerThread, errCh)
+		go readWorker(ctx, &wg, config, jobs, errCh)
+	}
+
+	for i, job := range chunks {
+		if ctx.Err() != nil {
+			break
+		}
+		job.index = i
+		jobs <- job
 	}
+	close(jobs)
 
 	wg.Wait()
 	close(errCh)
 	<-errDone
 
-	return calculateUniqueIpsUint32(ips), errs
+	// a stable sort keeps the errors of a chunk in their order
+	slices.SortStableFunc(chunkErrs, func(a, b chunkError) int {
+		return cmp.Compare(a.index, b.index)
+	})
+	errs := []error{}
+	for _, err := range chunkErrs {
+		errs = append(errs, err.err)
+	}
+	if err := stopMemoryWatch(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Function which reads the files one after another and prints the unique count of every file
+// together with the number of the unique IPs it added to the files read before it (the new bits of the combined set)
+// Every file is counted in its own sparse set next to the combined one, the chunks of a file are still read in parallel
+func readFilesOneByOne(ctx context.Context, config Config, files []inputFile) ([]FileResult, []error) {
+	results := []FileResult{}
+	errs := []error{}
+	for _, file := range files {
+		before := ips.Count()
+		fileIps = ipcount.NewSparseSet()
+		errs = append(errs, readFileChunks(ctx, config, []inputFile{file})...)
+		results = append(results, FileResult{Path: file.path, Unique: fileIps.Count(), New: ips.Count() - before})
+		if config.failFast && len(errs) > 0 {
+			break
+		}
+	}
+	fileIps = nil
+	return results, errs
+}
+
+// Function which counts the unique IP addresses of the input
+// Allocates the set, adds the addresses from the command line and reads the file,
+// plain files are split into chunks read in parallel, compressed ones are streamed
+// Failed reads return the partial count with their errors, a failed setup (the set, the lists, the
+// databases, the input files) returns a zero Result and its error, nothing was counted then
+func processIPFile(config Config) (Result, []error, error) {
+	// checked once here, otherwise every worker would fail on its own chunk with the same error
+	for _, path := range config.filePaths {
+		if err := checkReadable(path); err != nil {
+			return Result{}, nil, fmt.Errorf("can't read the input file: %w", err)
+		}
+	}
+
+	if config.mergeSorted {
+		unique, err := mergeSortedCount(config)
+		if err != nil {
+			return Result{Unique: unique}, []error{err}, nil
+		}
+		return Result{Unique: unique}, nil, nil
+	}
+
+	set, err := newSet(config)
+	if err != nil {
+		return Result{}, nil, err
+	}
+	ips = set
+	excluded = nil
+	for _, sentinel := range config.excluded {
+		excluded = append(excluded, binary.BigEndian.Uint32(sentinel.AsSlice()))
+	}
+	parseOnly = config.parseOnly
+	normalizeWhitespace = config.normalize
+	multiParser, _ = config.parser.(MultiLineParser)
+	rangeParser, _ = config.parser.(RangeLineParser)
+	maxRange = config.maxRange
+	if config.collisionSample > 0 {
+		collisions = newCollisionSampler(config.collisionSample)
+	}
+	if config.minOccurs > 0 {
+		occurrences = newOccurrenceCounter(config.minOccurs)
+	}
+	if config.countWindow > 0 {
+		rolling = newWindowCounter(config.countWindow)
+	}
+	if config.dupWindow > 0 {
+		duplicates = newDupWindow(config.dupWindow)
+	}
+	if config.windowsCsv != "" {
+		timeWindows = newBitsetMap()
+		timeParser = config.parser.(TimeLineParser)
+		windowSize = config.windowSize
+	}
+	if config.onlyPath != "" {
+		allowed = ipcount.NewSparseSet()
+		if err := readIpList(config.onlyPath, uint(32-config.networkBits), allowed); err != nil {
+			return Result{}, nil, err
+		}
+	}
+	if config.onePass {
+		if arrival, err = newArrivalWriter(config.writePath, config.networkBits, config.formatIp); err != nil {
+			return Result{}, nil, err
+		}
+		newIps = ips.(newAdder)
+	}
+	if config.baselinePath != "" {
+		if baseline, err = readBaseline(config.baselinePath, uint(32-config.networkBits)); err != nil {
+			return Result{}, nil, err
+		}
+	}
+	if config.asnDbPath != "" {
+		if asnDb, err = openMmdb(config.asnDbPath); err != nil {
+			return Result{}, nil, err
+		}
+	}
+	if config.geoDbPath != "" {
+		if geoDb, err = openMmdb(config.geoDbPath); err != nil {
+			return Result{}, nil, err
+		}
+	}
+
+	// the context of the run, -fail-fast and -max-errors cancel it so nothing is read after the files
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	networkShift := uint(32 - config.networkBits)
+	totalLines.Add(uint64(len(config.addresses)))
+	for _, address := range config.addresses {
+		if !processLine(config.parser, []byte(address), networkShift) {
+			skippedLines.Add(1)
+		}
+	}
+	if len(config.filePaths) == 0 {
+		if config.alsoStdin {
+			if err := readStdin(ctx, config); err != nil {
+				return newResult(config, nil), []error{err}, nil
+			}
+		}
+		return newResult(config, nil), nil, nil
+	}
+
+	files, err := inputFiles(config.filePaths, config.binary)
+	if err != nil {
+		return Result{}, nil, err
+	}
+
+	// the plain OR of a single writer can't be counted while it's written
+	dense, isDense := ips.(*IPSet)
+	stopSignal := handleCountSignal(isDense && dense.single)
+	defer stopSignal()
+
+	var errs []error
+	var perFile []FileResult
+	if config.follow {
+		if files[0].compression != "" {
+			return Result{}, nil, fmt.Errorf("-follow can't read %s compressed input", files[0].compression)
+		}
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		defer stop()
+		if err := followFile(ctx, config); err != nil {
+			errs = append(errs, err)
+		}
+	} else {
+		if config.estimate {
+			// estimates of the separate files are summed, the overlap between the files is not known
+			estimate := uint64(0)
+			for _, file := range files {
+				if file.compression != "" {
+					slog.Warn("-estimate-first is not supported for compressed input", "file", file.path)
+					continue
+				}
+				fileEstimate, err := estimateUniqueIps(config, file.path, file.size)
+				if err != nil {
+					return Result{}, nil, err
+				}
+				estimate += fileEstimate
+			}
+			estimate = min(estimate, uint64(1)<<config.networkBits)
+			slog.Info("estimated unique count", "estimate", estimate)
+			if float64(estimate) >= ESTIMATE_SATURATION*float64(uint64(1)<<config.networkBits) {
+				slog.Warn("estimate is close to the whole address space, the bitset will be nearly saturated", "estimate", estimate)
+			}
+		}
+		if config.progressPath != "" {
+			total := int64(0)
+			for _, file := range files {
+				total += file.size
+			}
+			stopProgress, err := reportProgressJson(config.progressPath, config.progressInterval, total)
+			if err != nil {
+				return Result{}, nil, err
+			}
+			defer stopProgress()
+		}
+		if config.watch > 0 {
+			defer watchCount(os.Stdout, config.watch)()
+		}
+		if config.countPerFile {
+			perFile, errs = readFilesOneByOne(ctx, config, files)
+		} else {
+			errs = readFileChunks(ctx, config, files)
+		}
+		if (config.failFast && len(errs) > 0) || (config.maxErrors > 0 && len(errs) >= config.maxErrors) {
+			cancel()
+		}
+		if config.alsoStdin && ctx.Err() == nil {
+			if err := readStdin(ctx, config); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if sparse, ok := ips.(*ipcount.SparseSet); ok {
+		slog.Debug("sparse set blocks", "blocks", sparse.BlockCount(), "bytes", sparse.BlockCount()*ipcount.SPARSE_BLOCK_WORDS*4)
+	}
+	if roaring, ok := ips.(*roaringSet); ok {
+		arrays, bitmaps := roaring.containerCount()
+		slog.Debug("roaring set containers", "arrays", arrays, "bitmaps", bitmaps)
+	}
+
+	return newResult(config, perFile), errs, nil
+}
+
+// Function which collects the counts of the finished run
+// The parse rate and the missing addresses of -gaps are filled in by main
+func newResult(config Config, perFile []FileResult) Result {
+	result := Result{
+		Unique:    ips.Count(),
+		Approx:    config.backend == "approx",
+		Human:     config.human,
+		Files:     perFile,
+		ParseOnly: config.parseOnly,
+		Parsed:    totalLines.Load() - skippedLines.Load(),
+		DupWindow: config.dupWindow,
+		Skipped:   skippedLines.Load(),
+		FreeText:  multiParser != nil,
+	}
+	if allowed != nil {
+		size := allowed.Count()
+		result.Allowlist = &size
+	}
+	if occurrences != nil {
+		result.MinOccurrences = config.minOccurs
+		result.Frequent = occurrences.frequent.Count()
+	}
+	if duplicates != nil {
+		result.Repeats = duplicates.events
+	}
+	if rangeParser != nil {
+		expanded := expandedAddresses.Load()
+		result.Expanded = &expanded
+		result.Oversized = oversizedRanges.Load()
+	}
+	if config.binary {
+		records := binaryRecords.Load()
+		result.Records = &records
+	}
+	if config.gaps.IsValid() {
+		result.Gaps = config.gaps.String()
+	}
+	if config.octets {
+		result.Octets = octetDistribution(ips, config.networkBits)
+	}
+	if config.classes {
+		result.Classes = classCounts(ips, config.networkBits)
+	}
+	if config.prefixSweep {
+		result.Prefixes = prefixCounts(ips, config.networkBits)
+	}
+	if config.specialUse {
+		result.SpecialUse = specialUseCounts(ips, config.networkBits)
+	}
+	if baseline != nil {
+		change := baselineChange(ips, baseline)
+		result.Baseline = &change
+	}
+	if collisions != nil {
+		result.Collisions = &collisions.report
+	}
+	if asnDb != nil {
+		report := asnCounts(ips, config.networkBits, asnDb, config.asnTop)
+		result.Asns = &report
+	}
+	if geoDb != nil {
+		report := countryCounts(ips, config.networkBits, geoDb)
+		result.Geo = &report
+	}
+	return result
 }
 
 func main() {
 	config := cli()
 
+	if config.plan {
+		if err := printPlan(os.Stdout, config); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	start := time.Now()
 
-	unique, errs := processIPFile(config)
+	stopCpuProfile := func() {}
+	if config.cpuProfile != "" {
+		var err error
+		stopCpuProfile, err = startCpuProfile(config.cpuProfile)
+		if err != nil {
+			fmt.Println("Error: Can't start the CPU profile:", err)
+			os.Exit(1)
+		}
+	}
+
+	result, errs, err := processIPFile(config)
 
+	// the profiles cover only the count phase, the outputs below are profiled separately if needed
+	stopCpuProfile()
+	if err != nil {
+		// nothing was counted, so there is no count to print
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if config.memProfile != "" {
+		if err := writeHeapProfile(config.memProfile); err != nil {
+			slog.Error("heap profile write failed", "err", err)
+		}
+	}
+
+	// the chunks of one file often fail with the same error, so each distinct error is logged once
+	errCounts := map[string]int{}
 	for _, err := range errs {
 		if err != nil {
-			fmt.Println("Error:", err)
+			errCounts[err.Error()]++
+		}
+	}
+	for _, err := range errs {
+		if err != nil && errCounts[err.Error()] > 0 {
+			slog.Error("read failed", "err", err, "occurrences", errCounts[err.Error()])
+			errCounts[err.Error()] = 0
+		}
+	}
+
+	if result.Oversized > 0 {
+		slog.Warn("ranges larger than -max-range were skipped", "ranges", result.Oversized, "max_range", config.maxRange)
+	}
+	if partial := partialRecords.Load(); partial > 0 {
+		slog.Warn("binary input ends with a partial record, its bytes were skipped", "bytes", partial)
+	}
+	// an empty or whitespace-only input is a valid input with the count 0, but it's usually the wrong file
+	if result.Unique == 0 && !config.parseOnly && len(errCounts) == 0 {
+		slog.Warn("no ip addresses found in the input", "lines", totalLines.Load(), "skipped_lines", result.Skipped)
+	}
+
+	if arrival != nil {
+		if err := arrival.close(); err != nil {
+			slog.Error("write failed", "err", err)
+		}
+	} else if config.writePath != "" {
+		if err := writeUniqueIps(config.writePath, ips, config.networkBits, config.formatIp, config.sorted, nil, config.numThreads); err != nil {
+			slog.Error("write failed", "err", err)
+		}
+	}
+	if config.binaryPath != "" {
+		if err := writeBinaryIps(config.binaryPath, ips, config.networkBits, config.numThreads, config.binaryChecksum); err != nil {
+			slog.Error("binary write failed", "err", err)
+		}
+	}
+	if config.addedPath != "" {
+		if err := writeUniqueIps(config.addedPath, setDifference(ips, baseline), config.networkBits, config.formatIp, false, nil, 1); err != nil {
+			slog.Error("added ips write failed", "err", err)
 		}
 	}
+	if config.removedPath != "" {
+		if err := writeUniqueIps(config.removedPath, setDifference(baseline, ips), config.networkBits, config.formatIp, false, nil, 1); err != nil {
+			slog.Error("removed ips write failed", "err", err)
+		}
+	}
+	if config.blocklistPath != "" {
+		if err := writeBlocklist(config.blocklistPath, ips, config.networkBits, config.filePaths, config.blocklistHeader, config.numThreads); err != nil {
+			slog.Error("blocklist export failed", "err", err)
+		}
+	}
+	if config.shardDir != "" {
+		if err := writeShardedIps(config.shardDir, ips, config.networkBits, config.formatIp); err != nil {
+			slog.Error("sharded write failed", "err", err)
+		}
+	}
+
+	if config.windowsCsv != "" {
+		if untimed := untimedLines.Load(); untimed > 0 {
+			slog.Warn("lines without a time are in no window", "lines", untimed)
+		}
+		count, err := writeWindowsCsv(config.windowsCsv, timeWindows, config.windowSize)
+		if err != nil {
+			slog.Error("windows csv write failed", "err", err)
+		} else {
+			slog.Info("windows csv written", "path", config.windowsCsv, "windows", count)
+		}
+	}
+
+	// with -watch stdout is the stream of the live counts
+	summaryOutput := io.Writer(os.Stdout)
+	if config.watch > 0 {
+		summaryOutput = os.Stderr
+	}
+	if config.parseOnly {
+		result.ParseRate = float64(totalLines.Load()) / time.Since(start).Seconds()
+	}
+	if config.gaps.IsValid() {
+		// the JSON output is a single object and -quiet a single number, so only the number of the missing addresses is reported
+		gapsOutput := summaryOutput
+		if config.output == "json" || config.quiet {
+			gapsOutput = io.Discard
+		}
+		missing, err := writeGaps(gapsOutput, ips, config.gaps, config.networkBits, config.formatIp)
+		if err != nil {
+			slog.Error("gaps write failed", "err", err)
+		}
+		result.Missing = missing
+	}
+	if config.output == "json" {
+		if err := json.NewEncoder(summaryOutput).Encode(result); err != nil {
+			slog.Error("result encoding failed", "err", err)
+		}
+	} else if config.quiet {
+		if config.parseOnly {
+			fmt.Fprintln(summaryOutput, result.Parsed)
+		} else {
+			fmt.Fprintln(summaryOutput, result.Unique)
+		}
+	} else {
+		fmt.Fprintln(summaryOutput, result)
+	}
 
-	fmt.Println("Unique ip count =", unique)
-	fmt.Println("Elapsed =", time.Since(start))
+	elapsed := time.Since(start)
+	fileSize := int64(0)
+	for _, path := range config.filePaths {
+		size, _ := getFileSize(path)
+		fileSize += size
+	}
+	slog.Info("finished", "elapsed", elapsed,
+		"throughput", fmt.Sprintf("%.1f MB/s", float64(fileSize)/(1<<20)/elapsed.Seconds()),
+		"line_rate", fmt.Sprintf("%.0f lines/s", float64(totalLines.Load())/elapsed.Seconds()))
+
+	// the count of a run with failed reads is partial, so it fails unless the caller accepts that
+	if len(errCounts) > 0 && !config.ignoreErrors {
+		os.Exit(1)
+	}
+
+	if config.expect >= 0 && result.Unique != uint64(config.expect) {
+		slog.Error("unique count differs from the expected count",
+			"expected", config.expect, "got", result.Unique, "diff", fmt.Sprintf("%+d", int64(result.Unique)-config.expect))
+		os.Exit(1)
+	}
 }
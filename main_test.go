@@ -0,0 +1,931 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"Lightspeed_Task/ipcount"
+)
+
+// Function which clears the global state a previous run left behind, like a new process
+func resetGlobals() {
+	ips, allowed, fileIps, baseline = nil, nil, nil, nil
+	arrival, newIps = nil, nil
+	excluded = nil
+	parseOnly, normalizeWhitespace = false, false
+	multiParser, rangeParser = nil, nil
+	occurrences, duplicates, rolling, collisions = nil, nil, nil, nil
+	timeWindows, timeParser = nil, nil
+	asnDb, geoDb = nil, nil
+	for _, counter := range []interface{ Store(uint64) }{&skippedLines, &totalLines, &processedBytes, &expandedAddresses,
+		&oversizedRanges, &binaryRecords, &partialRecords, &untimedLines} {
+		counter.Store(0)
+	}
+}
+
+// Function which returns the config of the command line defaults for the input files
+// The sparse set stands in for the 512MB array, so the runs of the tests stay cheap
+func testConfig(paths ...string) Config {
+	formatIp, _ := ipFormatter("dotted")
+	return Config{
+		filePaths:        paths,
+		parser:           DottedQuadParser{},
+		numThreads:       1,
+		networkBits:      32,
+		backend:          "sparse",
+		maxLineBytes:     BUFFER_SIZE,
+		minThreadBytes:   1 << 20,
+		formatIp:         formatIp,
+		output:           "text",
+		maxRange:         1 << 24,
+		asnTop:           10,
+		normalize:        true,
+		expect:           -1,
+		followInterval:   5 * time.Second,
+		progressInterval: time.Second,
+		windowSize:       time.Hour,
+		blocklistHeader:  true,
+	}
+}
+
+// Function which counts the input of the config like a run of the tool
+// A failed setup is returned as the only error, like a read error
+func runCount(t *testing.T, config Config) (Result, []error) {
+	t.Helper()
+	resetGlobals()
+	if err := validateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	result, errs, err := processIPFile(config)
+	if err != nil {
+		return result, []error{err}
+	}
+	return result, errs
+}
+
+// Function which counts the input of the config and fails the test on a read error
+func mustCount(t *testing.T, config Config) Result {
+	t.Helper()
+	result, errs := runCount(t, config)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	return result
+}
+
+// Function which writes the content to a new file of the test's temporary directory
+func writeTestFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Function which returns n lines of distinct addresses of different lengths (10.0.0.0, 10.0.0.1, ...)
+func ipLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255)
+	}
+	return lines
+}
+
+func TestOverlongLinesAreSkippedOnce(t *testing.T) {
+	garbage := "1.2.3.4\n" + strings.Repeat("x", 10_000_000) + "\n5.6.7.8\n" + strings.Repeat("y", 5_000_000) + "\n9.9.9.9\n"
+	tests := []struct {
+		name    string
+		content string
+		unique  uint64
+		skipped uint64
+	}{
+		{"two garbage lines", garbage, 3, 2},
+		{"no newline", strings.Repeat("z", 9_000_000), 0, 1},
+	}
+	for _, test := range tests {
+		path := writeTestFile(t, "input.txt", test.content)
+		for _, threads := range []int{1, 3, 7} {
+			t.Run(fmt.Sprintf("%s/t=%d", test.name, threads), func(t *testing.T) {
+				config := testConfig(path)
+				config.numThreads = threads
+				config.minThreadBytes = 0
+				result := mustCount(t, config)
+				if result.Unique != test.unique || result.Skipped != test.skipped {
+					t.Errorf("unique = %d, skipped = %d, want %d and %d", result.Unique, result.Skipped, test.unique, test.skipped)
+				}
+				if lines := totalLines.Load(); lines != test.skipped+test.unique {
+					t.Errorf("lines = %d, want %d", lines, test.skipped+test.unique)
+				}
+			})
+		}
+	}
+}
+
+// A 10MB line doesn't stop the chunk: it's one skipped line, the address glued to its end isn't counted
+// and the lines after it are read, whatever the -max-line-bytes
+func TestTenMegabyteLine(t *testing.T) {
+	long := strings.Repeat("1.2.3.", 10<<20/6) + "4"
+	content := "10.0.0.1\n10.0.0.2\n" + long + "\n10.0.0.3\n" + long + "\r\n10.0.0.4\n"
+	path := writeTestFile(t, "input.txt", content)
+	for _, maxLineBytes := range []int{64, BUFFER_SIZE} {
+		t.Run(fmt.Sprint(maxLineBytes), func(t *testing.T) {
+			config := testConfig(path)
+			config.maxLineBytes = maxLineBytes
+			result, errs := runCount(t, config)
+			if len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+			if result.Unique != 4 || result.Skipped != 2 || totalLines.Load() != 6 {
+				t.Errorf("unique = %d, skipped = %d, lines = %d, want 4, 2 and 6", result.Unique, result.Skipped, totalLines.Load())
+			}
+			if ips.Contains(0x01020304) {
+				t.Error("the address at the end of the long line was counted")
+			}
+		})
+	}
+}
+
+// The last line without a newline is counted whichever chunk it falls into, also when a chunk
+// border is right before it or inside of it
+// A failed setup counts nothing: the Result is zero and the error isn't one of the read errors
+func TestSetupErrors(t *testing.T) {
+	path := writeTestFile(t, "input.txt", "1.1.1.1\n2.2.2.2\n")
+	missing := filepath.Join(t.TempDir(), "missing")
+	tests := []struct {
+		name   string
+		adjust func(config *Config)
+	}{
+		{"missing -only-file", func(config *Config) { config.onlyPath = missing }},
+		{"missing baseline", func(config *Config) { config.baselinePath = missing }},
+		{"missing asn db", func(config *Config) { config.asnDbPath = missing }},
+		{"missing geo db", func(config *Config) { config.geoDbPath = missing }},
+		{"missing input", func(config *Config) { config.filePaths = []string{missing} }},
+		{"unknown backend", func(config *Config) { config.backend = "tree" }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetGlobals()
+			config := testConfig(path)
+			test.adjust(&config)
+			result, errs, err := processIPFile(config)
+			if err == nil || len(errs) > 0 || !reflect.DeepEqual(result, Result{}) {
+				t.Errorf("result %+v, read errors %v, setup error %v, want a zero result and a setup error", result, errs, err)
+			}
+		})
+	}
+}
+
+func TestLastLineWithoutNewline(t *testing.T) {
+	lines := ipLines(100)
+	for _, ending := range []string{"\n", "\r\n"} {
+		content := strings.Join(lines, ending)
+		path := writeTestFile(t, "input.txt", content)
+		lastStart := len(content) - len(lines[99])
+		for _, chunkSize := range []int{1, 7, lastStart, lastStart + 3, len(content) - 1, len(content), 1 << 20} {
+			config := testConfig(path)
+			config.numThreads = 4
+			config.chunkSize = chunkSize
+			result := mustCount(t, config)
+			if result.Unique != 100 || result.Skipped != 0 || !ips.Contains(0x0A000063) {
+				t.Errorf("%q endings, chunks of %d: unique = %d, skipped = %d, want 100 and 0 with 10.0.0.99", ending, chunkSize, result.Unique, result.Skipped)
+			}
+		}
+	}
+
+	// a lone address without any newline
+	path := writeTestFile(t, "input.txt", "10.0.0.1")
+	if result := mustCount(t, testConfig(path)); result.Unique != 1 || totalLines.Load() != 1 {
+		t.Errorf("single line: unique = %d, lines = %d, want 1 and 1", result.Unique, totalLines.Load())
+	}
+}
+
+// -min-thread-bytes keeps a small file in one chunk whatever the -t, larger files get one chunk per
+// floor up to -t chunks, and -t stays the upper bound
+func TestMinThreadBytes(t *testing.T) {
+	tests := []struct {
+		name           string
+		size           int64
+		threads        int
+		minThreadBytes int
+		chunks         int
+	}{
+		{"5KB file at -t 64", 5 << 10, 64, 1 << 20, 1},
+		{"3MB file at -t 64", 3 << 20, 64, 1 << 20, 3},
+		{"10MB file at -t 4", 10 << 20, 4, 1 << 20, 4},
+		{"5KB file without the floor", 5 << 10, 64, 0, 64},
+		{"5KB file with a 1KB floor", 5 << 10, 64, 1 << 10, 5},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.numThreads = test.threads
+		config.minThreadBytes = test.minThreadBytes
+		jobs := splitJobs(config, []inputFile{{path: "input.txt", size: test.size}})
+		if len(jobs) != test.chunks {
+			t.Errorf("%s: %d chunks, want %d", test.name, len(jobs), test.chunks)
+		}
+	}
+
+	// the one chunk of the small file is read by one thread and counts all of it
+	path := writeTestFile(t, "input.txt", strings.Join(ipLines(400), "\n")+"\n")
+	config := testConfig(path)
+	config.numThreads = 64
+	config.minThreadBytes = 1 << 20
+	if result := mustCount(t, config); result.Unique != 400 {
+		t.Errorf("unique = %d, want 400", result.Unique)
+	}
+}
+
+// The ranges of the chunks of every file are contiguous and don't overlap, and together they cover
+// the file: the first starts at 0 and only the last reaches the end, the partial remainder included
+func TestChunkRanges(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int64
+		threads   int
+		chunkSize int
+		binary    bool
+		chunks    int
+		lastBytes int64 // Bytes of the file in the last chunk
+	}{
+		{"even split", 100, 4, 0, false, 4, 25},
+		{"partial last chunk", 103, 4, 0, false, 4, 25},
+		{"one byte", 1, 4, 0, false, 1, 1},
+		{"more threads than bytes", 3, 8, 0, false, 3, 1},
+		{"threads equal to the bytes", 8, 8, 0, false, 8, 1},
+		{"chunk size", 50, 2, 7, false, 8, 1},
+		{"chunk size above the file", 50, 2, 64, false, 1, 50},
+		{"binary records", 40, 3, 0, true, 3, 8},
+		{"binary with more threads than records", 12, 8, 0, true, 3, 4},
+		{"empty file", 0, 4, 0, false, 0, 0},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.numThreads, config.minThreadBytes = test.threads, 0
+		config.chunkSize, config.binary = test.chunkSize, test.binary
+		jobs := splitJobs(config, []inputFile{{path: "a.txt", size: test.size}, {path: "b.txt", size: test.size}})
+		if len(jobs) != 2*test.chunks {
+			t.Errorf("%s: %d chunks, want %d per file", test.name, len(jobs), test.chunks)
+		}
+		if len(jobs) != 2*test.chunks || test.chunks == 0 {
+			continue
+		}
+		for file, path := range []string{"a.txt", "b.txt"} {
+			fileJobs := jobs[file*test.chunks : (file+1)*test.chunks]
+			end := int64(0)
+			for i, job := range fileJobs {
+				if job.path != path || job.offset != end {
+					t.Errorf("%s: chunk %d of %s is %s at %d, want it at %d", test.name, i, path, job.path, job.offset, end)
+				}
+				if offset, length := chunkRange(i, fileJobs[0].length); job.offset != offset || job.length != length {
+					t.Errorf("%s: chunk %d is [%d, +%d), chunkRange gives [%d, +%d)", test.name, i, job.offset, job.length, offset, length)
+				}
+				if test.binary && job.offset%BINARY_RECORD_SIZE != 0 {
+					t.Errorf("%s: chunk %d at %d cuts a record", test.name, i, job.offset)
+				}
+				end = job.offset + int64(job.length)
+				if last := i == len(fileJobs)-1; (end >= test.size) != last {
+					t.Errorf("%s: chunk %d of %d ends at %d of the %d bytes", test.name, i, len(fileJobs), end, test.size)
+				}
+			}
+			if last := fileJobs[len(fileJobs)-1]; test.size-last.offset != test.lastBytes {
+				t.Errorf("%s: last chunk holds %d bytes, want %d", test.name, test.size-last.offset, test.lastBytes)
+			}
+		}
+	}
+}
+
+// The file without any permission fails the upfront check with the permission error, before any worker
+// starts; root reads it anyway, so there the check is shown to fail on a directory instead
+func TestCheckReadable(t *testing.T) {
+	path := writeTestFile(t, "input.txt", "10.0.0.1\n")
+	if err := checkReadable(path); err != nil {
+		t.Fatalf("readable file: %v", err)
+	}
+	if err := checkReadable(writeTestFile(t, "empty.txt", "")); err != nil {
+		t.Errorf("empty file: %v", err)
+	}
+	if err := checkReadable(filepath.Join(t.TempDir(), "missing.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file: error %v, want not exist", err)
+	}
+	if err := checkReadable(t.TempDir()); err == nil {
+		t.Error("a directory passed the check")
+	}
+	// processIPFile checks the inputs before the workers start, so an unreadable one is a single setup error
+	resetGlobals()
+	if _, errs, err := processIPFile(testConfig(path, t.TempDir())); len(errs) > 0 || err == nil || !strings.Contains(err.Error(), "can't read the input file") {
+		t.Errorf("directory input: read errors %v, setup error %v, want the unreadable input", errs, err)
+	}
+
+	if err := os.Chmod(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("root reads the file without permissions")
+	}
+	if err := checkReadable(path); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("0-permission file: error %v, want permission denied", err)
+	}
+	config := testConfig(path)
+	config.numThreads, config.minThreadBytes = 4, 1
+	resetGlobals()
+	if _, errs, err := processIPFile(config); len(errs) > 0 || !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("0-permission input: read errors %v, setup error %v, want one permission error", errs, err)
+	}
+}
+
+// Parser which panics on one line, like a parser bug triggered by a single malformed input
+type panickingParser struct {
+	DottedQuadParser
+	trigger string
+}
+
+func (p panickingParser) Parse(line []byte) (uint32, bool) {
+	if string(line) == p.trigger {
+		var segments []uint32
+		return segments[4], true
+	}
+	return p.DottedQuadParser.Parse(line)
+}
+
+// A panic of the parser fails only the chunk it happened in, with the offending line in the error,
+// the other chunks are counted and the process keeps running
+func TestParserPanicFailsOnlyItsChunk(t *testing.T) {
+	lines := ipLines(1000)
+	lines[250] = "1.2.3.4.5"
+	content := strings.Join(lines, "\n") + "\n"
+	path := writeTestFile(t, "input.txt", content)
+	for _, threads := range []int{1, 4} {
+		config := testConfig(path)
+		config.parser = panickingParser{trigger: "1.2.3.4.5"}
+		config.numThreads = threads
+		config.chunkSize = len(content) / 4
+		result, errs := runCount(t, config)
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), `panic while processing line "1.2.3.4.5"`) {
+			t.Fatalf("-t %d: errors %v, want the panic with the line", threads, errs)
+		}
+		// the chunk of the panic counted the lines before it, the 3 other chunks all of theirs
+		if result.Unique < 750 || result.Unique >= 1000 || !ips.Contains(0x0A0003E7) {
+			t.Errorf("-t %d: unique = %d, want the 3 other chunks counted", threads, result.Unique)
+		}
+	}
+}
+
+// With more threads than lines most chunks hold no line start: they must read nothing of the lines
+// of their neighbours, so every line is counted exactly once
+func TestMoreThreadsThanLines(t *testing.T) {
+	content := strings.Join(ipLines(10), "\n") + "\n"
+	path := writeTestFile(t, "input.txt", content)
+	for _, minThreadBytes := range []int{0, 1 << 20} {
+		config := testConfig(path)
+		config.numThreads = 64
+		config.minThreadBytes = minThreadBytes
+		if jobs := splitJobs(config, []inputFile{{path: path, size: int64(len(content))}}); minThreadBytes == 0 && len(jobs) <= 10 {
+			t.Fatalf("%d chunks, want more than the lines", len(jobs))
+		}
+		result := mustCount(t, config)
+		if result.Unique != 10 || result.Skipped != 0 || totalLines.Load() != 10 {
+			t.Errorf("-min-thread-bytes %d: unique = %d, skipped = %d, lines = %d, want 10, 0 and 10", minThreadBytes, result.Unique, result.Skipped, totalLines.Load())
+		}
+	}
+}
+
+// The scanner buffers come from the pool, so a scan after another one must not see its stale bytes
+func TestScanBufferReuse(t *testing.T) {
+	resetGlobals()
+	ips = ipcount.NewSparseSet()
+	config := testConfig()
+	long := strings.Repeat("1.2.3.4\n", BUFFER_SIZE/8)
+	for _, content := range []string{long, "10.0.0.1", "", "10.0.0.2\n10."} {
+		if err := scanLines(context.Background(), config, strings.NewReader(content), 0, math.MaxInt, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count := ips.Count(); count != 3 || !ips.Contains(0x0A000001) || !ips.Contains(0x0A000002) {
+		t.Errorf("count = %d, want 1.2.3.4, 10.0.0.1 and 10.0.0.2", count)
+	}
+	if lines := totalLines.Load(); lines != BUFFER_SIZE/8+3 {
+		t.Errorf("lines = %d, want %d", lines, BUFFER_SIZE/8+3)
+	}
+}
+
+// Scans of short ranges with the buffer of the pool against a new 4MB buffer for every scan,
+// which the pool avoided: -benchmem shows the allocations per scan
+func BenchmarkScanBufferPool(b *testing.B) {
+	resetGlobals()
+	ips = ipcount.NewSparseSet()
+	config := testConfig()
+	content := strings.Join(ipLines(100), "\n") + "\n"
+	for _, pooled := range []bool{true, false} {
+		b.Run(map[bool]string{true: "pool", false: "fresh"}[pooled], func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if !pooled {
+					// a buffer taken out for good, so the scan has to allocate a new one
+					scanBufferPool.Get()
+				}
+				if err := scanLines(context.Background(), config, strings.NewReader(content), 0, math.MaxInt, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// The chunks of a 13MB file read through the regular reader (seek and read(2) from the offset of the chunk)
+// against the io_uring reader which keeps IOURING_DEPTH reads in flight, both counting into the array
+func BenchmarkReadFileChunks(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "input.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(ipLines(1<<20), "\n")+"\n"), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	files, err := inputFiles([]string{path}, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, ioUring := range []bool{false, true} {
+		for _, threads := range []int{1, 4} {
+			b.Run(fmt.Sprintf("%s/t=%d", map[bool]string{false: "read", true: "iouring"}[ioUring], threads), func(b *testing.B) {
+				if err := ioUringAvailable(); ioUring && err != nil {
+					b.Skip(err)
+				}
+				resetGlobals()
+				ips = NewIPSet(POW2_27)
+				config := testConfig()
+				config.numThreads, config.minThreadBytes, config.ioUring = threads, 0, ioUring
+				b.SetBytes(files[0].size)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if errs := readFileChunks(context.Background(), config, files); len(errs) > 0 {
+						b.Fatal(errs)
+					}
+				}
+			})
+		}
+	}
+}
+
+// The same file given twice, through a symlink, a hard link and another spelling of its path is read once,
+// a different file with the same content is not a duplicate
+func TestDedupeFiles(t *testing.T) {
+	path := writeTestFile(t, "input.txt", strings.Join(ipLines(100), "\n")+"\n")
+	dir := filepath.Dir(path)
+	symlink := filepath.Join(dir, "link.txt")
+	if err := os.Symlink(path, symlink); err != nil {
+		t.Fatal(err)
+	}
+	hardlink := filepath.Join(dir, "hard.txt")
+	if err := os.Link(path, hardlink); err != nil {
+		t.Fatal(err)
+	}
+	copied := filepath.Join(dir, "copy.txt")
+	content, _ := os.ReadFile(path)
+	if err := os.WriteFile(copied, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	spelled := filepath.Join(dir, ".", "sub", "..", "input.txt")
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := dedupeFiles([]string{path, path, symlink, hardlink, spelled, copied})
+	if len(paths) != 2 || paths[0] != path || paths[1] != copied {
+		t.Fatalf("deduplicated to %v, want %s and %s", paths, path, copied)
+	}
+
+	config := testConfig(dedupeFiles([]string{path, path, symlink})...)
+	if result := mustCount(t, config); result.Unique != 100 || totalLines.Load() != 100 {
+		t.Errorf("unique = %d, lines = %d, want 100 and 100", result.Unique, totalLines.Load())
+	}
+}
+
+// Files of nothing but blanks or newlines count nothing and fail nothing, also a 10MB line of spaces
+// longer than the scanner buffer; every blank line is reported as a skipped line
+func TestWhitespaceOnlyFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		lines   uint64
+	}{
+		{"newlines", strings.Repeat("\n", 10000), 10000},
+		{"CRLF", strings.Repeat("\r\n", 10000), 10000},
+		{"spaces and tabs", strings.Repeat(" \t \n", 1000), 1000},
+		{"spaces without newline", strings.Repeat(" ", 10<<20), 1},
+	}
+	for _, test := range tests {
+		path := writeTestFile(t, "input.txt", test.content)
+		for _, threads := range []int{1, 4} {
+			config := testConfig(path)
+			config.numThreads = threads
+			config.minThreadBytes = 0
+			result, errs := runCount(t, config)
+			if len(errs) > 0 || result.Unique != 0 {
+				t.Errorf("%s, -t %d: unique = %d, errors %v, want 0 and none", test.name, threads, result.Unique, errs)
+			}
+			if result.Skipped != test.lines || totalLines.Load() != test.lines {
+				t.Errorf("%s, -t %d: skipped = %d, lines = %d, want %d", test.name, threads, result.Skipped, totalLines.Load(), test.lines)
+			}
+		}
+	}
+}
+
+// Tab- and space-indented or padded lines are counted like the plain ones, and skipped without -normalize-whitespace
+func TestIndentedLines(t *testing.T) {
+	lines := []string{"\t10.0.0.1", "    10.0.0.2", " \t 10.0.0.3", "10.0.0.4\t", "10.0.0.5   ", "\t 10.0.0.6 \t", "10.0.0.7", "\t\t10.0.0.1"}
+	path := writeTestFile(t, "input.txt", strings.Join(lines, "\r\n")+"\r\n")
+	for _, test := range []struct {
+		normalize bool
+		unique    uint64
+		skipped   uint64
+	}{{true, 7, 0}, {false, 1, 7}} {
+		config := testConfig(path)
+		config.parser = DottedQuadParser{Strict: true}
+		config.normalize = test.normalize
+		if result := mustCount(t, config); result.Unique != test.unique || result.Skipped != test.skipped {
+			t.Errorf("normalize %v: unique = %d, skipped = %d, want %d and %d", test.normalize, result.Unique, result.Skipped, test.unique, test.skipped)
+		}
+	}
+
+	// the default parser only checks the length, it relies on the trimming to count the padded lines too
+	config := testConfig(path)
+	if result := mustCount(t, config); result.Unique != 7 || !ips.Contains(0x0A000006) {
+		t.Errorf("default parser: unique = %d, want 7 with 10.0.0.6", result.Unique)
+	}
+}
+
+// A pathological stream without newlines is dropped -max-line-scan-bytes at a time, the scanner never
+// holds more than its buffer and never fails with bufio.ErrTooLong, the lines after it are returned
+func TestLineSplitterPathologicalStream(t *testing.T) {
+	stream := io.MultiReader(strings.NewReader(strings.Repeat("9.", 1<<20)), strings.NewReader("\n1.2.3.4\n5.6.7.8"))
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64), 64)
+	splitter := lineSplitter{maxLineBytes: 16}
+	scanner.Split(splitter.split)
+	tokens := []string{}
+	for scanner.Scan() {
+		tokens = append(tokens, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[0] != "1.2.3.4" || tokens[1] != "5.6.7.8" {
+		t.Errorf("tokens %q, want 1.2.3.4 and 5.6.7.8", tokens)
+	}
+	if splitter.skippedLines != 1 || splitter.consumedBytes != 2<<20+16 {
+		t.Errorf("skipped = %d, consumed = %d, want 1 and %d", splitter.skippedLines, splitter.consumedBytes, 2<<20+16)
+	}
+}
+
+// A 32MB file without any newline is one skipped line whatever the limit and the chunks cutting it
+func TestPathologicalNoNewlineFile(t *testing.T) {
+	path := writeTestFile(t, "input.txt", strings.Repeat("255.", 8<<20))
+	for _, maxLineBytes := range []int{15, 4096, BUFFER_SIZE} {
+		for _, threads := range []int{1, 5} {
+			config := testConfig(path)
+			config.maxLineBytes = maxLineBytes
+			config.numThreads = threads
+			result, errs := runCount(t, config)
+			if len(errs) > 0 || result.Unique != 0 || result.Skipped != 1 || totalLines.Load() != 1 {
+				t.Errorf("limit %d, -t %d: unique = %d, skipped = %d, lines = %d, errors %v, want one skipped line",
+					maxLineBytes, threads, result.Unique, result.Skipped, totalLines.Load(), errs)
+			}
+		}
+	}
+}
+
+// Reader of a file which fails with err once it reaches the position failAt
+type failingReader struct {
+	file   *os.File
+	pos    int64
+	failAt int64
+	err    error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.pos >= r.failAt {
+		return 0, r.err
+	}
+	p = p[:min(int64(len(p)), r.failAt-r.pos)]
+	n, err := r.file.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *failingReader) Close() error {
+	return r.file.Close()
+}
+
+// Function which returns the opener of the file whose first opened reader fails at failAt,
+// the readers opened after it (the resumed range) read the file normally
+func transientFailure(path string, failAt int64, err error) func(pos int64) (io.ReadCloser, error) {
+	opened := 0
+	return func(pos int64) (io.ReadCloser, error) {
+		file, openErr := os.Open(path)
+		if openErr != nil {
+			return nil, openErr
+		}
+		if _, openErr = file.Seek(pos, io.SeekStart); openErr != nil {
+			file.Close()
+			return nil, openErr
+		}
+		opened++
+		if opened > 1 {
+			return file, nil
+		}
+		return &failingReader{file: file, pos: pos, failAt: failAt, err: err}, nil
+	}
+}
+
+func TestReadErrorMidChunk(t *testing.T) {
+	lines := ipLines(20000)
+	content := strings.Join(lines, "\n") + "\n"
+	path := writeTestFile(t, "input.txt", content)
+	failed := errors.New("input/output error")
+
+	for _, failAt := range []int64{0, 1, 100_000, int64(strings.Index(content, "10.0.50.0")), int64(len(content) - 3)} {
+		for _, skip := range []bool{false, true} {
+			t.Run(fmt.Sprintf("at=%d/skip=%v", failAt, skip), func(t *testing.T) {
+				// the lines read whole before the failure, and with skip the lines starting after the skipped bytes
+				resume := failAt + READ_SKIP_BYTES
+				expected := ipcount.NewSparseSet()
+				start := int64(0)
+				for _, line := range lines {
+					if start+int64(len(line)) < failAt || (skip && start >= resume) {
+						ip, _ := DottedQuadParser{}.Parse([]byte(line))
+						expected.Add(ip)
+					}
+					start += int64(len(line)) + 1
+				}
+				want := expected.Count()
+
+				resetGlobals()
+				ips = ipcount.NewSparseSet()
+				config := testConfig(path)
+				config.skipReadErrors = skip
+				errs := []error{}
+				fileRead(context.Background(), config, path, transientFailure(path, failAt, failed), 0, len(content), func(err error) {
+					if err != nil {
+						errs = append(errs, err)
+					}
+				})
+
+				if len(errs) != 1 || !errors.Is(errs[0], failed) {
+					t.Fatalf("errors = %v, want the one read error", errs)
+				}
+				if count := ips.Count(); count != want {
+					t.Errorf("count = %d, want %d", count, want)
+				}
+				// the line cut by the failure is dropped instead of parsed as a truncated address
+				ips.ForEach(func(ip uint32) {
+					if !expected.Contains(ip) {
+						t.Errorf("%s was counted", appendDottedIp(nil, ip))
+					}
+				})
+			})
+		}
+	}
+}
+
+// Fuzz target of the unvalidated parser: no input may panic it, the fast path must give the same
+// address for every input, and a valid dotted-quad must give the address netip.ParseAddr gives
+func FuzzBytesLineToUint32(f *testing.F) {
+	// 1.2.3.4.5 indexed past the four segments and panicked the whole run
+	for _, seed := range []string{"1.2.3.4.5", "1.2.3.4", "0.0.0.0", "255.255.255.255", "", ".", "....", "1..2.3", "999.1.1.1", "1.2.3.4\r"} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, line []byte) {
+		ip := bytesLineToUint32(line)
+		if fast := bytesLineToUint32Fast(line); fast != ip {
+			t.Fatalf("fast path gave %08x for %q, the loop %08x", fast, line, ip)
+		}
+		addr, err := netip.ParseAddr(string(line))
+		if err != nil || !addr.Is4() {
+			return
+		}
+		if octets := addr.As4(); ip != uint32(octets[0])<<24|uint32(octets[1])<<16|uint32(octets[2])<<8|uint32(octets[3]) {
+			t.Fatalf("%q parsed to %08x, netip gave %v", line, ip, addr)
+		}
+	})
+}
+
+// Every chunk of the run fails, the collector must keep each error exactly once in the chunk order,
+// stop the run at -fail-fast or -max-errors, and end together with the workers and the memory watcher
+func TestErrorCollectorLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	files := []inputFile{}
+	for i := range 90 {
+		// missing files, so the open of every chunk fails with its own path in the error
+		files = append(files, inputFile{path: filepath.Join(dir, fmt.Sprintf("missing-%02d.txt", i)), size: 100})
+	}
+	tests := []struct {
+		name    string
+		adjust  func(config *Config)
+		stopped bool
+		atLeast int
+	}{
+		{"all errors", func(config *Config) {}, false, 90},
+		{"fail fast", func(config *Config) { config.failFast = true }, true, 1},
+		{"max errors", func(config *Config) { config.maxErrors = 5 }, true, 5},
+		{"memory watcher", func(config *Config) { config.maxMemory = 1 << 40 }, false, 90},
+	}
+	before := runtime.NumGoroutine()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetGlobals()
+			ips = ipcount.NewSparseSet()
+			config := testConfig()
+			config.numThreads = 4
+			test.adjust(&config)
+
+			errs := readFileChunks(context.Background(), config, files)
+			if len(errs) < test.atLeast || (test.stopped && len(errs) >= len(files)) {
+				t.Fatalf("%d errors collected, want at least %d and stopped = %v", len(errs), test.atLeast, test.stopped)
+			}
+			last := -1
+			for _, err := range errs {
+				var index int
+				if _, scanErr := fmt.Sscanf(filepath.Base(strings.Fields(err.Error())[1]), "missing-%02d.txt", &index); scanErr != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if index <= last {
+					t.Fatalf("error of chunk %d after the one of chunk %d, duplicated or out of order", index, last)
+				}
+				last = index
+			}
+		})
+	}
+
+	// the collector, the workers and the watcher have returned once readFileChunks returns; the runtime
+	// may take a moment to retire the goroutines which are just exiting
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines before the runs, %d after them", before, after)
+	}
+}
+
+// A chunk opens one byte before its offset and drops the bytes up to the first newline, so when the
+// offset lands exactly on a newline the line after it belongs to the next chunk and must not be skipped
+func TestChunkOffsetOnNewline(t *testing.T) {
+	lines := make([]string, 100)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("10.0.0.%03d", i) // 10 bytes, the chunk sizes put offsets on the newlines, after them and mid-line
+	}
+	for _, ending := range []string{"\n", "\r\n"} {
+		path := writeTestFile(t, "input.txt", strings.Join(lines, ending)+ending)
+		for _, chunkSize := range []int{1, 2, 7, 8, 9, 10, 11, 12, 13, 21, 22} {
+			t.Run(fmt.Sprintf("%q/chunk=%d", ending, chunkSize), func(t *testing.T) {
+				config := testConfig(path)
+				config.numThreads = 4
+				config.chunkSize = chunkSize
+				result := mustCount(t, config)
+				if result.Unique != 100 || totalLines.Load() != 100 {
+					t.Errorf("unique = %d, lines = %d, want 100 and 100", result.Unique, totalLines.Load())
+				}
+			})
+		}
+	}
+
+	for _, offset := range []int64{8, 9} {
+		// 8 is the newline after 10.0.0.1 and 9 the first byte after it, both ranges own 10.0.0.2
+		t.Run(fmt.Sprintf("range at %d", offset), func(t *testing.T) {
+			path := writeTestFile(t, "input.txt", "10.0.0.1\n10.0.0.2\n10.0.0.3\n")
+			resetGlobals()
+			ips = ipcount.NewSparseSet()
+			config := testConfig(path)
+			if err := readRange(context.Background(), config, chunkOpener(config, path), offset, 9); err != nil {
+				t.Fatal(err)
+			}
+			if !ips.Contains(0x0A000002) || ips.Count() != 1 {
+				t.Errorf("the range counted %d addresses, want only 10.0.0.2", ips.Count())
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		adjust func(config *Config)
+		err    string // Part of the error message, empty for a valid config
+	}{
+		{"defaults", func(config *Config) {}, ""},
+		{"no input", func(config *Config) { config.filePaths = nil }, "is required"},
+		{"only addresses", func(config *Config) { config.filePaths, config.addresses = nil, []string{"1.2.3.4"} }, ""},
+		{"only stdin", func(config *Config) { config.filePaths, config.alsoStdin = nil, true }, ""},
+		{"stdin with follow", func(config *Config) { config.alsoStdin, config.follow = true, true }, "-also-stdin can't"},
+		{"zero threads", func(config *Config) { config.numThreads = 0 }, "Thread number"},
+		{"network bits 0", func(config *Config) { config.networkBits = 0 }, "Network bits"},
+		{"network bits 33", func(config *Config) { config.networkBits = 33 }, "Network bits"},
+		{"follow two files", func(config *Config) { config.follow, config.filePaths = true, []string{"a", "b"} }, "exactly one file"},
+		{"negative dup window", func(config *Config) { config.dupWindow = -1 }, "Dup window"},
+		{"count window without follow", func(config *Config) { config.countWindow = time.Second }, "-count-window"},
+		{"progress with follow", func(config *Config) { config.progressPath, config.follow = "fd:2", true }, "-progress-json"},
+		{"watch with parse only", func(config *Config) { config.watch, config.parseOnly = time.Second, true }, "-watch"},
+		{"expect below -1", func(config *Config) { config.expect = -2 }, "Expected count"},
+		{"negative chunk size", func(config *Config) { config.chunkSize = -1 }, "Chunk size"},
+		{"max line bytes 0", func(config *Config) { config.maxLineBytes = 0 }, "Max line scan bytes"},
+		{"max line bytes above the buffer", func(config *Config) { config.maxLineBytes = BUFFER_SIZE + 1 }, "Max line scan bytes"},
+		{"unknown backend", func(config *Config) { config.backend = "btree" }, "Unknown set backend"},
+		{"approx backend", func(config *Config) { config.backend = "approx" }, ""},
+		{"approx with write", func(config *Config) { config.backend, config.writePath = "approx", "out.txt" }, "-approx can't"},
+		{"warmup of the sparse set", func(config *Config) { config.warmup = true }, "-warmup"},
+		{"unknown output", func(config *Config) { config.output = "xml" }, "Unknown output format"},
+		{"json with quiet", func(config *Config) { config.output, config.quiet = "json", true }, "-o json"},
+		{"ipv6 gaps", func(config *Config) { config.gaps = netip.MustParsePrefix("2001:db8::/120") }, "IPv4 CIDR range"},
+		{"ipv4 gaps", func(config *Config) { config.gaps = netip.MustParsePrefix("10.0.0.0/24") }, ""},
+		{"ipv6 sentinel", func(config *Config) { config.excluded = []netip.Addr{netip.MustParseAddr("::")} }, "sentinel"},
+		{"delimiter without auto", func(config *Config) { config.delimiter = ',' }, "-delimiter requires"},
+		{"delimiter with auto", func(config *Config) { config.delimiter, config.parser = ',', FieldParser{Field: -1, Delimiter: ','} }, ""},
+		{"sorted without write", func(config *Config) { config.sorted = true }, "-sorted requires"},
+		{"checksum without write-binary", func(config *Config) { config.binaryChecksum = true }, "-write-binary-checksum requires"},
+		{"one pass of the roaring set", func(config *Config) {
+			config.onePass, config.writePath, config.backend = true, "out.txt", "roaring"
+		}, "atomic bits"},
+		{"baseline outputs without baseline", func(config *Config) { config.addedPath = "added.txt" }, "require -baseline"},
+		{"collisions of weblog lines", func(config *Config) { config.collisionSample, config.parser = 10, WebLogParser{} }, "-count-collisions"},
+		{"plan without files", func(config *Config) { config.plan, config.filePaths, config.addresses = true, nil, []string{"1.2.3.4"} }, "-plan"},
+		{"binary with auto backend", func(config *Config) { config.binary, config.backend = true, "auto" }, "-binary"},
+		{"memory below the array", func(config *Config) { config.backend, config.maxMemory = "array", 100<<20 }, "-max-memory"},
+		{"windows of dotted lines", func(config *Config) { config.windowsCsv = "windows.csv" }, "-windows-csv"},
+		{"windows below a second", func(config *Config) {
+			config.windowsCsv, config.parser, config.windowSize = "windows.csv", WebLogParser{}, time.Millisecond
+		}, "Window size"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig("input.txt")
+			test.adjust(&config)
+			err := validateConfig(config)
+			switch {
+			case test.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Errorf("error = %v, want one containing %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestResolveBackend(t *testing.T) {
+	tests := []struct {
+		backend string
+		sparse  bool
+		approx  bool
+		want    string
+		ok      bool
+	}{
+		{"array", false, false, "array", true},
+		{"roaring", false, false, "roaring", true},
+		{"array", true, false, "sparse", true},
+		{"sparse", true, false, "sparse", true},
+		{"roaring", true, false, "", false},
+		{"array", false, true, "approx", true},
+		{"hashset", false, true, "", false},
+		{"array", true, true, "", false},
+	}
+	for _, test := range tests {
+		backend, err := resolveBackend(test.backend, test.sparse, test.approx)
+		if backend != test.want || (err == nil) != test.ok {
+			t.Errorf("resolveBackend(%q, %v, %v) = %q, %v", test.backend, test.sparse, test.approx, backend, err)
+		}
+	}
+}
+
+func TestFlagValues(t *testing.T) {
+	gaps := &cidrRange{}
+	if err := gaps.Set("10.0.0.5/24"); err != nil || gaps.String() != "10.0.0.0/24" {
+		t.Errorf("-gaps 10.0.0.5/24 = %s, %v", gaps, err)
+	}
+	if err := gaps.Set("10.0.0.0/33"); err == nil {
+		t.Error("-gaps accepted a /33")
+	}
+	sentinels := &addressList{}
+	if err := sentinels.Set("0.0.0.0, 10.0.0.1"); err != nil || sentinels.String() != "0.0.0.0,10.0.0.1" {
+		t.Errorf("-sentinels = %s, %v", sentinels, err)
+	}
+	if err := sentinels.Set("0.0.0.0,x"); err == nil {
+		t.Error("-sentinels accepted x")
+	}
+	delimiter := &fieldDelimiter{}
+	for value, ok := range map[string]bool{",": true, `\t`: true, "|": true, ".": false, ",,": false, "": false} {
+		if err := delimiter.Set(value); (err == nil) != ok {
+			t.Errorf("-delimiter %q: %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MEMORY_CHECK_INTERVAL = 100 * time.Millisecond // How often -max-memory compares the memory with the budget
+	MEMORY_HEADROOM       = 0.9                    // Share of the budget at which the reading is stopped
+)
+
+// Function which stops the reading before the memory of the process exceeds the budget
+// The memory the runtime holds from the OS is checked every MEMORY_CHECK_INTERVAL, at 90% of the budget
+// cancel stops the workers, so the count stays the partial count of what was read until then
+// The returned function ends the checks and returns the error when the budget was hit
+func watchMemory(ctx context.Context, budget uint64, cancel func()) func() error {
+	done := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(MEMORY_CHECK_INTERVAL)
+		defer ticker.Stop()
+		var stats runtime.MemStats
+		for {
+			select {
+			case <-done:
+				result <- nil
+				return
+			case <-ctx.Done():
+				result <- nil
+				return
+			case <-ticker.C:
+				runtime.ReadMemStats(&stats)
+				used := stats.Sys - stats.HeapReleased
+				if float64(used) >= MEMORY_HEADROOM*float64(budget) {
+					slog.Debug("memory budget reached", "used", used, "budget", budget)
+					cancel()
+					result <- fmt.Errorf("memory budget of %d MB reached (%d MB in use), the count is partial", budget>>20, used>>20)
+					return
+				}
+			}
+		}
+	}()
+	return func() error {
+		close(done)
+		return <-result
+	}
+}
+
+// Probe of the memory the process can still get, a variable so the tests can stand in for the system
+var availableMemory = func() (uint64, bool) { return availableMemoryAt("/") }
+
+// Function which returns how much memory the process can still get: the lowest of the memory the system
+// has available (MemAvailable) and the room left under the limit of its cgroup (v2 or v1)
+// The files of /proc and /sys are read under root, "/" outside the tests
+// ok is false when none of them is known, e.g. outside linux
+func availableMemoryAt(root string) (uint64, bool) {
+	available, ok := meminfoAvailable(root)
+	cgroups := []struct{ controller, limit, usage string }{
+		{"", "memory.max", "memory.current"},                         // cgroup v2
+		{"memory", "memory.limit_in_bytes", "memory.usage_in_bytes"}, // cgroup v1
+	}
+	for _, cgroup := range cgroups {
+		dir, found := cgroupDir(root, cgroup.controller)
+		if !found {
+			continue
+		}
+		max, maxOk := readUintFile(filepath.Join(dir, cgroup.limit))
+		used, usedOk := readUintFile(filepath.Join(dir, cgroup.usage))
+		// cgroup v2 reports no limit as "max", v1 as a huge number rounded to the page size
+		if !maxOk || !usedOk || max >= 1<<62 {
+			continue
+		}
+		room := uint64(0)
+		if max > used {
+			room = max - used
+		}
+		if !ok || room < available {
+			available, ok = room, true
+		}
+	}
+	return available, ok
+}
+
+// Function which returns the MemAvailable of /proc/meminfo in bytes
+func meminfoAvailable(root string) (uint64, bool) {
+	data, err := os.ReadFile(filepath.Join(root, "proc/meminfo"))
+	if err != nil {
+		return 0, false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			return kb * 1024, err == nil
+		}
+	}
+	return 0, false
+}
+
+// Function which finds the directory of the process cgroup of the controller ("" for the cgroup v2 hierarchy)
+// In a container the cgroup filesystem is often mounted at the process cgroup itself, then the mount root is used
+func cgroupDir(root string, controller string) (string, bool) {
+	data, err := os.ReadFile(filepath.Join(root, "proc/self/cgroup"))
+	if err != nil {
+		return "", false
+	}
+	mount := filepath.Join(root, "sys/fs/cgroup", controller)
+	for _, line := range strings.Split(string(data), "\n") {
+		// hierarchy-id:controllers:path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || !slices.Contains(strings.Split(parts[1], ","), controller) {
+			continue
+		}
+		for _, dir := range []string{filepath.Join(mount, parts[2]), mount} {
+			if _, err := os.Stat(filepath.Join(dir, "cgroup.procs")); err == nil {
+				return dir, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Function which reads the file with a single number, like the files of the cgroups ("max" is no number)
+func readUintFile(path string) (uint64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return value, err == nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMeminfo = "MemTotal:       16384000 kB\nMemFree:         1024000 kB\nMemAvailable:    8192000 kB\n"
+
+// The available memory is the lowest of MemAvailable and the room under the cgroup limit, read from
+// fixtures of /proc and /sys: cgroup v2 and v1, the cgroup mounted at its own path or at the mount
+// root like in a container, and the limits which mean no limit
+func TestAvailableMemory(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		available uint64
+		ok        bool
+	}{
+		{"nothing known", map[string]string{}, 0, false},
+		{"meminfo only", map[string]string{"proc/meminfo": testMeminfo}, 8192000 << 10, true},
+		{"meminfo without MemAvailable", map[string]string{"proc/meminfo": "MemTotal: 16384000 kB\n"}, 0, false},
+		{"cgroup v2 below meminfo", map[string]string{
+			"proc/meminfo":     testMeminfo,
+			"proc/self/cgroup": "0::/app.slice/counter\n",
+			"sys/fs/cgroup/app.slice/counter/cgroup.procs":   "",
+			"sys/fs/cgroup/app.slice/counter/memory.max":     "1073741824\n",
+			"sys/fs/cgroup/app.slice/counter/memory.current": "268435456\n",
+		}, 768 << 20, true},
+		{"cgroup v2 without a limit", map[string]string{
+			"proc/meminfo":     testMeminfo,
+			"proc/self/cgroup": "0::/app.slice/counter\n",
+			"sys/fs/cgroup/app.slice/counter/cgroup.procs":   "",
+			"sys/fs/cgroup/app.slice/counter/memory.max":     "max\n",
+			"sys/fs/cgroup/app.slice/counter/memory.current": "268435456\n",
+		}, 8192000 << 10, true},
+		{"cgroup v2 mounted at the container cgroup", map[string]string{
+			"proc/self/cgroup":             "0::/docker/0123abcd\n",
+			"sys/fs/cgroup/cgroup.procs":   "",
+			"sys/fs/cgroup/memory.max":     "536870912\n",
+			"sys/fs/cgroup/memory.current": "134217728\n",
+		}, 384 << 20, true},
+		{"cgroup v2 above its limit", map[string]string{
+			"proc/meminfo":                 testMeminfo,
+			"proc/self/cgroup":             "0::/\n",
+			"sys/fs/cgroup/cgroup.procs":   "",
+			"sys/fs/cgroup/memory.max":     "1048576\n",
+			"sys/fs/cgroup/memory.current": "2097152\n",
+		}, 0, true},
+		{"cgroup v1", map[string]string{
+			"proc/meminfo":     testMeminfo,
+			"proc/self/cgroup": "5:cpuset:/\n4:memory:/batch/counter\n0::/\n",
+			"sys/fs/cgroup/memory/batch/counter/cgroup.procs":          "",
+			"sys/fs/cgroup/memory/batch/counter/memory.limit_in_bytes": "2147483648\n",
+			"sys/fs/cgroup/memory/batch/counter/memory.usage_in_bytes": "1073741824\n",
+		}, 1 << 30, true},
+		{"cgroup v1 with joined controllers", map[string]string{
+			"proc/meminfo":                               testMeminfo,
+			"proc/self/cgroup":                           "4:memory,hugetlb:/\n",
+			"sys/fs/cgroup/memory/cgroup.procs":          "",
+			"sys/fs/cgroup/memory/memory.limit_in_bytes": "1073741824\n",
+			"sys/fs/cgroup/memory/memory.usage_in_bytes": "0\n",
+		}, 1 << 30, true},
+		{"cgroup v1 without a limit", map[string]string{
+			"proc/meminfo":                               testMeminfo,
+			"proc/self/cgroup":                           "4:memory:/\n",
+			"sys/fs/cgroup/memory/cgroup.procs":          "",
+			"sys/fs/cgroup/memory/memory.limit_in_bytes": "9223372036854771712\n",
+			"sys/fs/cgroup/memory/memory.usage_in_bytes": "1073741824\n",
+		}, 8192000 << 10, true},
+		{"cgroup without the limit files", map[string]string{
+			"proc/meminfo":               testMeminfo,
+			"proc/self/cgroup":           "0::/\n",
+			"sys/fs/cgroup/cgroup.procs": "",
+		}, 8192000 << 10, true},
+	}
+	for _, test := range tests {
+		root := t.TempDir()
+		for path, content := range test.files {
+			path = filepath.Join(root, path)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if available, ok := availableMemoryAt(root); available != test.available || ok != test.ok {
+			t.Errorf("%s: available = %d, %v, want %d, %v", test.name, available, ok, test.available, test.ok)
+		}
+	}
+}
+
+// Without the memory for the array an explicit -backend array is an error and auto falls back to the
+// roaring set, with the memory or when it's unknown the array is allocated
+func TestNewSetMemoryFallback(t *testing.T) {
+	probe := availableMemory
+	defer func() { availableMemory = probe }()
+
+	tests := []struct {
+		name      string
+		backend   string
+		available uint64
+		ok        bool
+		want      string // Type of the set, empty for the error
+	}{
+		{"array without the memory", "array", 1 << 20, true, ""},
+		{"auto without the memory", "auto", 1 << 20, true, "*main.roaringSet"},
+		{"array with the memory", "array", 4 << 20, true, "*main.IPSet"},
+		{"auto with the memory", "auto", 4 << 20, true, "*main.IPSet"},
+		{"array of unknown memory", "array", 0, false, "*main.IPSet"},
+	}
+	for _, test := range tests {
+		availableMemory = func() (uint64, bool) { return test.available, test.ok }
+		config := testConfig()
+		config.backend, config.networkBits = test.backend, 24 // a 2MB array
+		set, err := newSet(config)
+		switch {
+		case test.want == "" && (err == nil || !strings.Contains(err.Error(), "only 1 MB of memory are available")):
+			t.Errorf("%s: error %v, want the missing memory", test.name, err)
+		case test.want != "" && err != nil:
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		case test.want != "" && fmt.Sprintf("%T", set) != test.want:
+			t.Errorf("%s: set %T, want %s", test.name, set, test.want)
+		}
+	}
+}
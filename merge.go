@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"os"
+)
+
+// Sorted input file of the k-way merge with its current (smallest not merged) network
+type mergeInput struct {
+	path    string
+	scanner *bufio.Scanner
+	network uint32 // Current network of the file
+}
+
+// Min-heap of the merge inputs ordered by their current network
+type mergeHeap []*mergeInput
+
+func (h mergeHeap) Len() int           { return len(h) }
+func (h mergeHeap) Less(i, j int) bool { return h[i].network < h[j].network }
+func (h mergeHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)        { *h = append(*h, x.(*mergeInput)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	input := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return input
+}
+
+// Function which moves the input to its next network, the lines without an IP address are skipped
+// Returns false at the end of the file, fails when the file is not in ascending order
+func (in *mergeInput) next(config Config, networkShift uint) (bool, error) {
+	for in.scanner.Scan() {
+		totalLines.Add(1)
+		ipUint32, ok := config.parser.Parse(in.scanner.Bytes())
+		if !ok {
+			skippedLines.Add(1)
+			continue
+		}
+		if network := ipUint32 >> networkShift; network >= in.network {
+			in.network = network
+			return true, nil
+		}
+		return false, fmt.Errorf("%s is not sorted: %s after %s", in.path,
+			appendDottedIp(nil, ipUint32), appendDottedIp(nil, in.network<<networkShift))
+	}
+	return false, in.scanner.Err()
+}
+
+// Function which counts the distinct networks of the files which are already sorted in ascending order
+// (e.g. the -write output) without the bitset: the files are merged with a min-heap holding the current
+// line of every file, so the memory is O(number of files) and every line is read once
+func mergeSortedCount(config Config) (uint64, error) {
+	networkShift := uint(32 - config.networkBits)
+	inputs := mergeHeap{}
+	for _, path := range config.filePaths {
+		file, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), BUFFER_SIZE)
+		input := &mergeInput{path: path, scanner: scanner}
+		ok, err := input.next(config, networkShift)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			inputs = append(inputs, input)
+		}
+	}
+	heap.Init(&inputs)
+
+	unique := uint64(0)
+	last := uint32(0)
+	for inputs.Len() > 0 {
+		input := inputs[0]
+		if unique == 0 || input.network != last {
+			unique++
+			last = input.network
+		}
+
+		ok, err := input.next(config, networkShift)
+		if err != nil {
+			return unique, err
+		}
+		if ok {
+			heap.Fix(&inputs, 0)
+		} else {
+			heap.Pop(&inputs)
+		}
+	}
+	return unique, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+const (
+	MMDB_DATA_SEPARATOR = 16 // Zero bytes between the search tree and the data section
+)
+
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Reader of a MaxMind DB file (GeoLite2, GeoIP2, DB-IP and the other .mmdb databases), read into memory at once
+// Only what the lookups of IPv4 addresses need is implemented: the binary search tree with records of 24, 28
+// or 32 bits, and the decoder of the data section, no dependency is added
+type mmdbReader struct {
+	tree         []byte      // Search tree, nodeCount nodes of two records
+	nodeCount    uint32      // Number of nodes of the tree, a record equal to it means no data
+	recordSize   int         // Bits of one record
+	data         mmdbDecoder // Data section between the tree and the metadata
+	ipv4Start    uint32      // Record reached by the 96 zero bits of an IPv6 tree, where the IPv4 addresses start
+	databaseType string      // database_type of the metadata, e.g. GeoLite2-ASN
+}
+
+// Function which reads the database file and checks its metadata
+func openMmdb(path string) (*mmdbReader, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	metaStart := bytes.LastIndex(file, mmdbMetadataMarker)
+	if metaStart < 0 {
+		return nil, fmt.Errorf("%s is not a MaxMind DB file, the metadata marker is missing", path)
+	}
+	value, _, err := mmdbDecoder(file[metaStart+len(mmdbMetadataMarker):]).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("metadata of %s: %w", path, err)
+	}
+	metadata, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("metadata of %s is not a map", path)
+	}
+	nodeCount, _ := metadata["node_count"].(uint64)
+	recordSize, _ := metadata["record_size"].(uint64)
+	ipVersion, _ := metadata["ip_version"].(uint64)
+	databaseType, _ := metadata["database_type"].(string)
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("%s has records of %d bits, only 24, 28 and 32 are supported", path, recordSize)
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("%s has the unknown ip_version %d", path, ipVersion)
+	}
+	treeSize := nodeCount * recordSize / 4
+	if nodeCount == 0 || nodeCount >= math.MaxUint32 || treeSize+MMDB_DATA_SEPARATOR > uint64(metaStart) {
+		return nil, fmt.Errorf("%s has a search tree of %d nodes which doesn't fit the file", path, nodeCount)
+	}
+
+	r := &mmdbReader{
+		tree:         file[:treeSize],
+		nodeCount:    uint32(nodeCount),
+		recordSize:   int(recordSize),
+		data:         file[treeSize+MMDB_DATA_SEPARATOR : metaStart],
+		databaseType: databaseType,
+	}
+	// the IPv4 addresses of an IPv6 tree are ::a.b.c.d, the node after 96 zero bits
+	if ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// Function which reads the left (bit 0) or the right (bit 1) record of the node
+func (r *mmdbReader) record(node uint32, bit uint32) uint32 {
+	switch r.recordSize {
+	case 24:
+		b := r.tree[node*6+bit*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		// the middle byte holds the top 4 bits of both records, of the left one in its high nibble
+		b := r.tree[node*7:]
+		if bit == 0 {
+			return uint32(b[3]>>4)<<24 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	}
+	return binary.BigEndian.Uint32(r.tree[node*8+bit*4:])
+}
+
+// Function which walks the search tree for the IPv4 address
+// Returns the offset of its record in the data section and the prefix length of the network the record
+// belongs to, ok is false when the database has no data for the address
+func (r *mmdbReader) lookup(ip uint32) (offset int, prefix int, ok bool, err error) {
+	node, depth := r.ipv4Start, 0
+	for ; depth < 32 && node < r.nodeCount; depth++ {
+		node = r.record(node, ip>>(31-depth)&1)
+	}
+	switch {
+	case node == r.nodeCount:
+		return 0, depth, false, nil
+	case node < r.nodeCount:
+		return 0, depth, false, fmt.Errorf("invalid MaxMind DB: the search tree is deeper than 32 bits below the IPv4 node")
+	}
+	offset = int(node-r.nodeCount) - MMDB_DATA_SEPARATOR
+	if offset < 0 || offset >= len(r.data) {
+		return 0, depth, false, fmt.Errorf("invalid MaxMind DB: record points to %d outside of the data section", offset)
+	}
+	return offset, depth, true, nil
+}
+
+// Lookups of the addresses of a set in ascending order, the result of an address is reused for the following
+// addresses of the same network of the database: a whole /24 has one record in most geo and ASN databases,
+// so a dense /24 costs one walk of the tree instead of up to 256
+type mmdbCache struct {
+	db     *mmdbReader
+	first  uint32 // Network of the cached lookup, from first to last
+	last   uint32
+	cached bool
+	offset int  // Cached result
+	ok     bool // The database has a record for the network
+	walks  uint64
+}
+
+// Function which returns the record of the address like lookup, from the cache when the address is in
+// the network of the previous lookup
+func (c *mmdbCache) lookup(ip uint32) (int, bool, error) {
+	if c.cached && ip >= c.first && ip <= c.last {
+		return c.offset, c.ok, nil
+	}
+	offset, prefix, ok, err := c.db.lookup(ip)
+	if err != nil {
+		c.cached = false
+		return 0, false, err
+	}
+	c.walks++
+	mask := uint32(1)<<(32-prefix) - 1 // all ones for prefix 0, the shift by 32 gives 0
+	c.first, c.last, c.cached = ip&^mask, ip|mask, true
+	c.offset, c.ok = offset, ok
+	return offset, ok, nil
+}
+
+// Data section of a MaxMind DB, the values are decoded into map[string]any, []any, string, []byte,
+// uint64 (all unsigned types, uint128 only up to 64 bits), int64, float64 and bool
+type mmdbDecoder []byte
+
+var errMmdbTruncated = errors.New("invalid MaxMind DB: value runs past the end of the data section")
+
+// Function which decodes the value at the offset, returns it and the offset of the next value
+// A pointer is followed, the next offset is then the one after the pointer
+func (d mmdbDecoder) decode(offset int) (any, int, error) {
+	if offset >= len(d) {
+		return nil, 0, errMmdbTruncated
+	}
+	control := d[offset]
+	offset++
+	kind := int(control >> 5)
+	if kind == 1 {
+		// pointer: the size bits select the width of the target offset
+		width := int(control>>3&3) + 1
+		if offset+width > len(d) {
+			return nil, 0, errMmdbTruncated
+		}
+		target := 0
+		if width < 4 {
+			target = int(control & 7)
+		}
+		for _, b := range d[offset : offset+width] {
+			target = target<<8 | int(b)
+		}
+		target += [...]int{0, 2048, 526336, 0}[width-1]
+		// a pointer to a pointer is invalid, following it could loop forever
+		if target < len(d) && d[target]>>5 == 1 {
+			return nil, 0, errors.New("invalid MaxMind DB: pointer to a pointer")
+		}
+		value, _, err := d.decode(target)
+		return value, offset + width, err
+	}
+	if kind == 0 {
+		if offset >= len(d) {
+			return nil, 0, errMmdbTruncated
+		}
+		kind = 7 + int(d[offset])
+		offset++
+	}
+	size := int(control & 0x1f)
+	if size >= 29 {
+		width := size - 28
+		if offset+width > len(d) {
+			return nil, 0, errMmdbTruncated
+		}
+		extra := 0
+		for _, b := range d[offset : offset+width] {
+			extra = extra<<8 | int(b)
+		}
+		offset += width
+		size = [...]int{29, 285, 65821}[width-1] + extra
+	}
+
+	switch kind {
+	case 7: // map
+		m := make(map[string]any, size)
+		for range size {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("invalid MaxMind DB: map key of type %T", key)
+			}
+			if m[name], offset, err = d.decode(next); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case 11: // array
+		a := make([]any, 0, size)
+		for range size {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case 14: // boolean, the value is the size
+		return size != 0, offset, nil
+	}
+
+	if offset+size > len(d) {
+		return nil, 0, errMmdbTruncated
+	}
+	payload := d[offset : offset+size]
+	offset += size
+	switch kind {
+	case 2: // UTF-8 string
+		return string(payload), offset, nil
+	case 3: // double
+		if size != 8 {
+			return nil, 0, fmt.Errorf("invalid MaxMind DB: double of %d bytes", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), offset, nil
+	case 4: // bytes
+		return payload, offset, nil
+	case 5, 6, 8, 9, 10: // uint16, uint32, int32, uint64, uint128
+		if size > 8 {
+			payload = payload[size-8:]
+		}
+		n := uint64(0)
+		for _, b := range payload {
+			n = n<<8 | uint64(b)
+		}
+		if kind == 8 {
+			return int64(int32(n)), offset, nil
+		}
+		return n, offset, nil
+	case 15: // float
+		if size != 4 {
+			return nil, 0, fmt.Errorf("invalid MaxMind DB: float of %d bytes", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(payload))), offset, nil
+	}
+	return nil, 0, fmt.Errorf("invalid MaxMind DB: unknown data type %d", kind)
+}
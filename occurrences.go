@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"Lightspeed_Task/ipcount"
+)
+
+const (
+	SKETCH_DEPTH = 4       // Number of count-min sketch rows
+	SKETCH_WIDTH = 1 << 22 // Counters per row, 4 rows * 4M * uint32 = 64MB
+)
+
+// Count-min sketch of the IP occurrences shared by all workers
+// Every row counts the address in the counter selected by its own hash and the estimate is
+// the minimum over the rows. Collisions only add to the counters, so the estimate is never
+// below the real number of occurrences
+type countMinSketch struct {
+	rows [SKETCH_DEPTH][]uint32
+}
+
+func newCountMinSketch() *countMinSketch {
+	s := &countMinSketch{}
+	for i := range s.rows {
+		s.rows[i] = make([]uint32, SKETCH_WIDTH)
+	}
+	return s
+}
+
+// Function which counts one occurrence of the IP address and returns its estimated number of occurrences
+// The row indexes are derived from the two halves of one 64-bit hash (double hashing)
+func (s *countMinSketch) add(ip uint32) uint32 {
+	hash := hashIp(ip)
+	h1, h2 := uint32(hash), uint32(hash>>32)|1
+
+	estimate := ^uint32(0)
+	for i := range s.rows {
+		idx := (h1 + uint32(i)*h2) % SKETCH_WIDTH
+		estimate = min(estimate, atomic.AddUint32(&s.rows[i][idx], 1))
+	}
+	return estimate
+}
+
+// Counter of the distinct IP addresses which were seen at least threshold times
+// An address is added to the frequent set once its estimate reaches the threshold, so the
+// count is exact for the addresses but may include a few rare ones whose counters collided
+type occurrenceCounter struct {
+	sketch    *countMinSketch
+	threshold uint32
+	frequent  Set
+}
+
+func newOccurrenceCounter(threshold int) *occurrenceCounter {
+	return &occurrenceCounter{
+		sketch:    newCountMinSketch(),
+		threshold: uint32(threshold),
+		frequent:  ipcount.NewSparseSet(),
+	}
+}
+
+func (c *occurrenceCounter) add(ip uint32) {
+	if c.sketch.add(ip) >= c.threshold {
+		c.frequent.Add(ip)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WRITE_SEGMENT_WORDS = 1 << 16 // Words of the array formatted by one goroutine of -write at a time (2M addresses)
+	PREFIX_SWEEP_MIN    = 8       // Shortest prefix length of -count-distinct-per-prefix-length
+)
+
+// Function which appends the text form of the IP address to the buffer
+type ipFormatFunc func(buf []byte, ip uint32) []byte
+
+// Function which appends the dotted-quad form of the IP address to the buffer
+func appendDottedIp(buf []byte, ip uint32) []byte {
+	buf = strconv.AppendUint(buf, uint64(ip>>24), 10)
+	buf = append(buf, '.')
+	buf = strconv.AppendUint(buf, uint64(ip>>16&255), 10)
+	buf = append(buf, '.')
+	buf = strconv.AppendUint(buf, uint64(ip>>8&255), 10)
+	buf = append(buf, '.')
+	return strconv.AppendUint(buf, uint64(ip&255), 10)
+}
+
+// Function which appends the decimal integer form of the IP address to the buffer
+func appendIntIp(buf []byte, ip uint32) []byte {
+	return strconv.AppendUint(buf, uint64(ip), 10)
+}
+
+// Function which appends the hexadecimal form of the IP address to the buffer
+// Always zero-padded to 8 digits, so all lines have the same width and sort lexicographically
+func appendHexIp(buf []byte, ip uint32) []byte {
+	const digits = "0123456789abcdef"
+	buf = append(buf, '0', 'x')
+	for shift := 28; shift >= 0; shift -= 4 {
+		buf = append(buf, digits[ip>>shift&15])
+	}
+	return buf
+}
+
+// Function which returns the function formatting IP addresses in the output format
+func ipFormatter(format string) (ipFormatFunc, error) {
+	switch format {
+	case "dotted":
+		return appendDottedIp, nil
+	case "int":
+		return appendIntIp, nil
+	case "hex":
+		return appendHexIp, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, expected dotted, int or hex", format)
+}
+
+// Function which appends the output line of the network: the address rendered by formatIp,
+// followed by the prefix length for networkBits < 32 (10.0.0.0/24)
+func appendNetworkLine(buf []byte, network uint32, networkBits int, formatIp ipFormatFunc) []byte {
+	buf = formatIp(buf, network<<(32-networkBits))
+	if networkBits < 32 {
+		buf = append(buf, '/')
+		buf = strconv.AppendInt(buf, int64(networkBits), 10)
+	}
+	return append(buf, '\n')
+}
+
+// Writer of -count-unique-and-write-in-one-pass which writes every address the first time a worker adds it,
+// so the file is complete when the reading ends, in the order of arrival instead of ascending order
+// The line is formatted by the worker and written under the lock, the first write error is kept for close
+type arrivalWriter struct {
+	mu          sync.Mutex
+	file        *os.File
+	writer      *bufio.Writer
+	networkBits int
+	formatIp    ipFormatFunc
+	err         error
+}
+
+func newArrivalWriter(name string, networkBits int, formatIp ipFormatFunc) (*arrivalWriter, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &arrivalWriter{file: file, writer: bufio.NewWriterSize(file, BUFFER_SIZE), networkBits: networkBits, formatIp: formatIp}, nil
+}
+
+func (w *arrivalWriter) write(network uint32) {
+	var buf [32]byte
+	line := appendNetworkLine(buf[:0], network, w.networkBits, w.formatIp)
+	w.mu.Lock()
+	if _, err := w.writer.Write(line); err != nil && w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+}
+
+// Function which flushes the written lines and closes the file, it returns the first error of the writes
+func (w *arrivalWriter) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.writer.Flush(); err != nil && w.err == nil {
+		w.err = err
+	}
+	if err := w.file.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// Function which writes every IP address present in the set to the file, one per line
+// The bit index encodes the IP value, so the addresses naturally come out in ascending order
+// With verifySorted every address is checked to be greater than the previous one
+// For networkBits < 32 the network address is written with its prefix length (10.0.0.0/24)
+// The addresses are rendered by formatIp (dotted, int or hex)
+// Every header line is written first as a # comment
+// The array backend is formatted by the given number of threads, the other backends by one
+func writeUniqueIps(name string, set Set, networkBits int, formatIp ipFormatFunc, verifySorted bool, header []string, threads int) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriterSize(file, BUFFER_SIZE)
+	for _, line := range header {
+		fmt.Fprintf(writer, "# %s\n", line)
+	}
+
+	appendLine := func(buf []byte, network uint32) []byte {
+		return appendNetworkLine(buf, network, networkBits, formatIp)
+	}
+	if err := writeSet(writer, set, threads, appendLine, verifySorted); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Function which writes every IP address present in the set to the file as a 4-byte big-endian record,
+// the binary counterpart of writeUniqueIps which is read back by -binary and -baseline
+// With checksum the records follow the dump header and every frame of them is followed by its checksum
+// (see dumpWriter), otherwise the file holds only the records
+// For networkBits < 32 the network address is written, without the prefix length
+func writeBinaryIps(name string, set Set, networkBits int, threads int, checksum bool) error {
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriterSize(file, BUFFER_SIZE)
+	var records io.Writer = writer
+	var dump *dumpWriter
+	if checksum {
+		if dump, err = newDumpWriter(writer, set.Count()); err != nil {
+			return err
+		}
+		records = dump
+	}
+	appendRecord := func(buf []byte, network uint32) []byte {
+		return binary.BigEndian.AppendUint32(buf, network<<(32-networkBits))
+	}
+	if err := writeSet(records, set, threads, appendRecord, false); err != nil {
+		return err
+	}
+	if dump != nil {
+		if err := dump.Close(); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Function which writes the record of every network of the set in ascending order, rendered by appendLine
+// The array backend is rendered by the given number of threads (see writeWordsParallel), the other backends by one
+// With verifySorted every network is checked to be greater than the previous one
+func writeSet(writer io.Writer, set Set, threads int, appendLine func(buf []byte, network uint32) []byte, verifySorted bool) error {
+	if dense, ok := set.(*IPSet); ok && threads > 1 {
+		return writeWordsParallel(writer, dense.words, threads, appendLine, verifySorted)
+	}
+
+	buf := make([]byte, 0, 32)
+	var orderErr, writeErr error
+	var prev uint32
+	written := 0
+	set.ForEach(func(network uint32) {
+		if verifySorted && written > 0 && network <= prev && orderErr == nil {
+			orderErr = fmt.Errorf("unique IPs are not sorted: %d written after %d", network, prev)
+		}
+		prev = network
+		written++
+
+		buf = appendLine(buf[:0], network)
+		if _, err := writer.Write(buf); err != nil && writeErr == nil {
+			writeErr = err
+		}
+	})
+
+	if orderErr != nil {
+		return orderErr
+	}
+	return writeErr
+}
+
+// Function which writes the lines of the set bits of the array in ascending order using several goroutines
+// The array is split into segments of WRITE_SEGMENT_WORDS words, every goroutine formats one contiguous
+// segment into its own buffer and the buffers are written in the segment order, so the output is the same
+// as the one of the serial iteration. The segments are formatted in rounds of threads, which bounds the
+// memory to threads buffers (up to ~32MB each for a full segment of dotted-quads)
+// With verifySorted the order is checked inside every segment and between the neighbouring segments
+func writeWordsParallel(writer io.Writer, words []uint32, threads int, appendLine func(buf []byte, network uint32) []byte, verifySorted bool) error {
+	buffers := make([][]byte, threads)
+	orderErrs := make([]error, threads)
+	firsts := make([]uint32, threads)
+	lasts := make([]uint32, threads)
+	var prev uint32
+	written := false
+
+	for roundStart := 0; roundStart < len(words); roundStart += threads * WRITE_SEGMENT_WORDS {
+		var wg sync.WaitGroup
+		for i := range threads {
+			start := roundStart + i*WRITE_SEGMENT_WORDS
+			if start >= len(words) {
+				buffers[i] = buffers[i][:0]
+				continue
+			}
+			end := min(start+WRITE_SEGMENT_WORDS, len(words))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				buf := buffers[i][:0]
+				count := 0
+				forEachIpUint32Arr(words[start:end], func(offset uint32) {
+					network := uint32(start)<<5 + offset
+					if verifySorted && count > 0 && network <= lasts[i] && orderErrs[i] == nil {
+						orderErrs[i] = fmt.Errorf("unique IPs are not sorted: %d written after %d", network, lasts[i])
+					}
+					if count == 0 {
+						firsts[i] = network
+					}
+					lasts[i] = network
+					count++
+					buf = appendLine(buf, network)
+				})
+				buffers[i] = buf
+			}()
+		}
+		wg.Wait()
+
+		for i, buf := range buffers {
+			if len(buf) == 0 {
+				continue
+			}
+			if orderErrs[i] != nil {
+				return orderErrs[i]
+			}
+			if verifySorted && written && firsts[i] <= prev {
+				return fmt.Errorf("unique IPs are not sorted: %d written after %d", firsts[i], prev)
+			}
+			prev, written = lasts[i], true
+			if _, err := writer.Write(buf); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Function which writes the unique IP addresses as a blocklist accepted by the common firewall import tools
+// (ipset, iptables/nftables scripts, pfSense/OPNsense URL tables): one dotted-quad address or CIDR network
+// per line, optionally preceded by # comments with the generation date, the sources and the count
+func writeBlocklist(name string, set Set, networkBits int, sources []string, withHeader bool, threads int) error {
+	header := []string{}
+	if withHeader {
+		if len(sources) == 0 {
+			sources = []string{"command line"}
+		}
+		header = []string{
+			"Unique IP blocklist",
+			"Generated: " + time.Now().UTC().Format(time.RFC3339),
+			"Source: " + strings.Join(sources, ", "),
+			"Count: " + strconv.FormatUint(set.Count(), 10),
+		}
+	}
+	return writeUniqueIps(name, set, networkBits, appendDottedIp, false, header, threads)
+}
+
+// Function which writes the unique IP addresses into one file per /8 (0.txt .. 255.txt) in the directory
+// The set is iterated in ascending order, so the shards are written one after another and only
+// one file is open at a time. Files are created only for the non-empty shards, manifest.txt lists
+// every created shard file with its number of lines
+func writeShardedIps(dir string, set Set, networkBits int, formatIp ipFormatFunc) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	var file *os.File
+	var writer *bufio.Writer
+	var writeErr error
+	manifest := []byte{}
+	shard, shardCount := -1, 0
+	buf := make([]byte, 0, 32)
+
+	// Function which flushes the current shard and appends it to the manifest
+	closeShard := func() {
+		if file == nil {
+			return
+		}
+		if err := writer.Flush(); err != nil && writeErr == nil {
+			writeErr = err
+		}
+		if err := file.Close(); err != nil && writeErr == nil {
+			writeErr = err
+		}
+		manifest = fmt.Appendf(manifest, "%d.txt %d\n", shard, shardCount)
+		file = nil
+	}
+
+	set.ForEach(func(network uint32) {
+		if writeErr != nil {
+			return
+		}
+		if ipShard := int(network << (32 - networkBits) >> 24); ipShard != shard {
+			closeShard()
+			shard, shardCount = ipShard, 0
+			file, writeErr = os.Create(filepath.Join(dir, strconv.Itoa(shard)+".txt"))
+			if writeErr != nil {
+				return
+			}
+			writer = bufio.NewWriterSize(file, BUFFER_SIZE)
+		}
+		shardCount++
+		buf = appendNetworkLine(buf[:0], network, networkBits, formatIp)
+		writer.Write(buf)
+	})
+	closeShard()
+
+	if writeErr != nil {
+		return writeErr
+	}
+	return os.WriteFile(filepath.Join(dir, "manifest.txt"), manifest, 0o644)
+}
+
+// Function which writes every address of the range which is not present in the set, one per line
+// It's the complement of writeUniqueIps restricted to the range, e.g. the unused addresses of an allocation
+// With networkBits < 32 the missing networks of the range are written
+// Returns the number of the missing addresses (networks)
+func writeGaps(w io.Writer, set Set, prefix netip.Prefix, networkBits int, formatIp ipFormatFunc) (uint64, error) {
+	writer := bufio.NewWriterSize(w, BUFFER_SIZE)
+	networkShift := uint(32 - networkBits)
+	octets := prefix.Addr().As4()
+	firstIp, lastIp := prefixRange(uint32(octets[0])<<24|uint32(octets[1])<<16|uint32(octets[2])<<8|uint32(octets[3]), prefix.Bits())
+	first, last := uint64(firstIp), uint64(lastIp)
+	buf := make([]byte, 0, 32)
+
+	missing := uint64(0)
+	for network := first >> networkShift; network <= last>>networkShift; network++ {
+		if set.Contains(uint32(network)) {
+			continue
+		}
+		missing++
+		buf = appendNetworkLine(buf[:0], uint32(network), networkBits, formatIp)
+		writer.Write(buf)
+	}
+	return missing, writer.Flush()
+}
+
+// Function which counts how many unique addresses have each value in each of the 4 octet positions
+// All 4 histograms are filled in one pass over the set, with -network-bits every network is counted
+// by its first address, so the octets past the prefix are all 0
+func octetDistribution(set Set, networkBits int) *[4][256]uint64 {
+	histograms := new([4][256]uint64)
+	shift := uint(32 - networkBits)
+	set.ForEach(func(network uint32) {
+		ip := network << shift
+		histograms[0][ip>>24]++
+		histograms[1][ip>>16&255]++
+		histograms[2][ip>>8&255]++
+		histograms[3][ip&255]++
+	})
+	return histograms
+}
+
+// Distinct networks of one prefix length
+type PrefixCount struct {
+	Bits   int    `json:"bits"`   // Prefix length
+	Unique uint64 `json:"unique"` // Distinct networks of that length
+}
+
+// Function which counts the distinct networks of every prefix length from networkBits down to PREFIX_SWEEP_MIN
+// (or just networkBits below it) in a single pass over the set
+// The networks come in ascending order, so a network starts a new network of every prefix length longer than
+// the common prefix with the previous one: the common prefix length is counted per network, and the count of
+// length p is the sum of the networks whose common prefix is shorter than p
+func prefixCounts(set Set, networkBits int) []PrefixCount {
+	var shorter [33]uint64 // Networks by the length of the common prefix with the previous network
+	shift := uint(32 - networkBits)
+	var prev uint32
+	first := true
+	set.ForEach(func(network uint32) {
+		ip := network << shift
+		if first {
+			shorter[0]++
+			first = false
+		} else {
+			shorter[bits.LeadingZeros32(ip^prev)]++
+		}
+		prev = ip
+	})
+
+	counts := []PrefixCount{}
+	for prefixBits := networkBits; prefixBits >= min(PREFIX_SWEEP_MIN, networkBits); prefixBits-- {
+		unique := uint64(0)
+		for common := 0; common < prefixBits; common++ {
+			unique += shorter[common]
+		}
+		counts = append(counts, PrefixCount{Bits: prefixBits, Unique: unique})
+	}
+	return counts
+}
+
+// Function which counts the unique addresses of each classful range, A to E
+// The class is the number of the leading one bits of the address (A is 0xxx, B 10xx, C 110x, D 1110, E 1111),
+// with -network-bits every network is counted by its first address
+func classCounts(set Set, networkBits int) *[5]uint64 {
+	classes := new([5]uint64)
+	shift := uint(32 - networkBits)
+	set.ForEach(func(network uint32) {
+		classes[min(bits.LeadingZeros32(^(network<<shift)), 4)]++
+	})
+	return classes
+}
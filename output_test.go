@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Function which returns the line appender of -write for the dotted-quad addresses
+func dottedLines(buf []byte, network uint32) []byte {
+	return append(appendDottedIp(buf, network), '\n')
+}
+
+// The parallel formatting of the array writes the same bytes as the serial iteration, with segments
+// empty, partly and fully set, and more threads than segments
+func TestWriteWordsParallelMatchesSerial(t *testing.T) {
+	set := NewIPSet(bitsetWords(24))
+	for _, ip := range randomIps(100000, 5) {
+		set.Add(ip >> 8)
+	}
+	for i := 3 * WRITE_SEGMENT_WORDS; i < 4*WRITE_SEGMENT_WORDS; i++ {
+		set.words[i] = ^uint32(0)
+	}
+	for i := 5 * WRITE_SEGMENT_WORDS; i < 6*WRITE_SEGMENT_WORDS; i++ {
+		set.words[i] = 0
+	}
+
+	var serial bytes.Buffer
+	if err := writeSet(&serial, set, 1, dottedLines, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, threads := range []int{2, 3, 8, 300} {
+		var parallel bytes.Buffer
+		if err := writeSet(&parallel, set, threads, dottedLines, true); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(parallel.Bytes(), serial.Bytes()) {
+			t.Errorf("-t %d: %d bytes differ from the %d serial ones", threads, parallel.Len(), serial.Len())
+		}
+	}
+}
+
+// Serial iteration against the parallel formatting of the 512MB array with 8M addresses
+func BenchmarkWriteSet(b *testing.B) {
+	set := NewIPSet(POW2_27)
+	for _, ip := range randomIps(8<<20, 6) {
+		set.Add(ip)
+	}
+	for _, threads := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("t=%d", threads), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := writeSet(io.Discard, set, threads, dottedLines, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// The -write file of every backend lists each address of a shuffled input with duplicates once,
+// in strictly ascending order, also when the networks are written
+func TestWriteIsStrictlyAscending(t *testing.T) {
+	input := randomIps(20000, 7)
+	input = append(input, input[:5000]...)
+	input = append(input, 0, 0, ^uint32(0), 0x0A000001, 0x0A000001, 0x0A0000FF)
+	rand.New(rand.NewPCG(7, 7)).Shuffle(len(input), func(i, j int) { input[i], input[j] = input[j], input[i] })
+	lines := make([]string, len(input))
+	for i, ip := range input {
+		lines[i] = string(appendDottedIp(nil, ip))
+	}
+	path := writeTestFile(t, "input.txt", strings.Join(lines, "\n")+"\n")
+
+	tests := []struct {
+		backend     string
+		networkBits int
+	}{
+		{"array", 24},
+		{"array", 32},
+		{"sparse", 32},
+		{"sparse", 24},
+		{"roaring", 32},
+		{"hashset", 32},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s/%d", test.backend, test.networkBits), func(t *testing.T) {
+			config := testConfig(path)
+			config.backend, config.networkBits, config.numThreads = test.backend, test.networkBits, 4
+			result := mustCount(t, config)
+
+			want := map[uint32]bool{}
+			for _, ip := range input {
+				want[ip>>(32-test.networkBits)<<(32-test.networkBits)] = true
+			}
+			out := filepath.Join(t.TempDir(), "out.txt")
+			if err := writeUniqueIps(out, ips, test.networkBits, appendDottedIp, false, nil, 4); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			written := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+			if uint64(len(written)) != result.Unique || len(written) != len(want) {
+				t.Fatalf("%d lines written, unique = %d, want %d", len(written), result.Unique, len(want))
+			}
+			previous := int64(-1)
+			for _, line := range written {
+				addr, err := netip.ParseAddr(strings.TrimSuffix(line, fmt.Sprintf("/%d", test.networkBits)))
+				if err != nil {
+					t.Fatalf("unexpected line %q", line)
+				}
+				ip := binary.BigEndian.Uint32(addr.AsSlice())
+				if int64(ip) <= previous || !want[ip] {
+					t.Fatalf("%q written after %s, want strictly ascending input addresses", line, string(appendDottedIp(nil, uint32(previous))))
+				}
+				previous = int64(ip)
+			}
+		})
+	}
+}
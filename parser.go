@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"Lightspeed_Task/ipcount"
+)
+
+// Parser which extracts the IP address from a single line of the input, defined by the library
+// so the parsers of the embedders and the built-in ones below are interchangeable
+type LineParser = ipcount.LineParser
+
+// Parser which can find several IP addresses in one line, every one of them is counted
+type MultiLineParser interface {
+	LineParser
+	// ParseNext returns the first IP address of the line and the rest of the line after it,
+	// ok is false when there is no more address
+	ParseNext(line []byte) (ip uint32, rest []byte, ok bool)
+}
+
+// Parser of the lines which hold a whole range of IP addresses, every address of the range is counted
+type RangeLineParser interface {
+	LineParser
+	// ParseRange returns the first and the last address of the inclusive range in the line
+	ParseRange(line []byte) (first uint32, last uint32, ok bool)
+}
+
+// Parser which also finds the time of the line, the lines are assigned to the -window-size windows by it
+type TimeLineParser interface {
+	LineParser
+	// ParseTime returns the time of the line, ok is false when it has none
+	ParseTime(line []byte) (t time.Time, ok bool)
+}
+
+const (
+	MIN_IP_LENGTH = 7  // Length of "0.0.0.0"
+	MAX_IP_LENGTH = 15 // Length of "255.255.255.255"
+
+	WEBLOG_TIME_LAYOUT = "02/Jan/2006:15:04:05 -0700" // Time field of the Common Log Format, [10/Oct/2000:13:55:36 -0700]
+)
+
+// Parser of the lines which contain only a dotted-quad IP address (the default format)
+// IPv4-mapped IPv6 addresses (::ffff:1.2.3.4) are counted as the embedded IPv4 address
+// By default only the length is checked, Strict accepts exactly the IPv4 addresses accepted by netip.ParseAddr
+// With MultiFormat the hexadecimal (0x01020304) and decimal (16909060) forms are accepted as well,
+// they are normalized to the same uint32, so one address written in different notations counts once
+type DottedQuadParser struct {
+	Strict      bool // Reject everything which is not a valid dotted-quad, like netip.ParseAddr
+	MultiFormat bool // Also accept the hexadecimal and decimal integer forms of the address
+}
+
+func (p DottedQuadParser) Parse(line []byte) (uint32, bool) {
+	line = ipcount.TrimMappedPrefix(line)
+	if p.MultiFormat {
+		if ip, ok, isInt := parseIntIp(line); isInt {
+			return ip, ok
+		}
+	}
+	if p.Strict {
+		return ipcount.ParseDottedQuad(line)
+	}
+	// "0.0.0.0" is the shortest address and "255.255.255.255" the longest one, the \r of the CRLF
+	// line endings is already stripped by the scanner, so a longer line can't be an address
+	if len(line) < MIN_IP_LENGTH || len(line) > MAX_IP_LENGTH {
+		return 0, false
+	}
+	return bytesLineToUint32Fast(line), true
+}
+
+// Parser of the web server access logs (Common and Combined Log Format)
+// The client IP address is the first space separated field of the line,
+// it's parsed by the embedded DottedQuadParser with its options
+type WebLogParser struct {
+	DottedQuadParser
+}
+
+func (p WebLogParser) Parse(line []byte) (uint32, bool) {
+	if end := bytes.IndexByte(line, ' '); end >= 0 {
+		line = line[:end]
+	}
+	return p.DottedQuadParser.Parse(line)
+}
+
+// ParseTime returns the request time, the bracketed field after the client IP, ident and user fields
+func (p WebLogParser) ParseTime(line []byte) (time.Time, bool) {
+	start := bytes.IndexByte(line, '[')
+	if start < 0 {
+		return time.Time{}, false
+	}
+	end := bytes.IndexByte(line[start:], ']')
+	if end < 0 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(WEBLOG_TIME_LAYOUT, string(line[start+1:start+end]))
+	return t, err == nil
+}
+
+// Parser of the JSON Lines records which hold the IP address as a string field
+// The field is located by scanning for the quoted key, no full JSON decoding is done
+type JSONLParser struct {
+	key     []byte           // Quoted field key, e.g. "ip"
+	address DottedQuadParser // Parser of the field value
+}
+
+func NewJSONLParser(key string, address DottedQuadParser) JSONLParser {
+	return JSONLParser{key: []byte(`"` + key + `"`), address: address}
+}
+
+func (p JSONLParser) Parse(line []byte) (uint32, bool) {
+	value, ok := p.findValue(line)
+	if !ok || len(value) == 0 || value[0] != '"' {
+		return 0, false
+	}
+	end := bytes.IndexByte(value[1:], '"')
+	if end < 0 {
+		return 0, false
+	}
+	return p.address.Parse(value[1 : end+1])
+}
+
+// Function which returns the line after the colon of the key with the leading whitespace trimmed
+// The quoted key also appears as a value ({"x":"ip","ip":"1.2.3.4"}) or escaped inside of a value,
+// so a hit counts only when it's not escaped and is followed by optional whitespace and ':',
+// otherwise the search continues after it
+func (p JSONLParser) findValue(line []byte) ([]byte, bool) {
+	for {
+		keyIdx := bytes.Index(line, p.key)
+		if keyIdx < 0 {
+			return nil, false
+		}
+		rest := line[keyIdx+len(p.key):]
+		value := bytes.TrimLeft(rest, " \t")
+		if len(value) > 0 && value[0] == ':' && (keyIdx == 0 || line[keyIdx-1] != '\\') {
+			return bytes.TrimLeft(value[1:], " \t"), true
+		}
+		// The closing quote of a false hit may open the next string, so the search restarts on it
+		line = line[keyIdx+len(p.key)-1:]
+	}
+}
+
+// Parser of free-form text lines (application logs, emails, ...) which finds every dotted-quad IP address
+// The line is scanned once by hand, which is much cheaper than running the regexp package on every line
+// An address must have 4 segments of 1-3 digits up to 255 and must not be glued to other digits or dots,
+// so the version 1.2.3.4.5 or the digits 11.2.3.4 inside of 211.2.3.4 are not counted
+type TextParser struct{}
+
+func (p TextParser) Parse(line []byte) (uint32, bool) {
+	ip, _, ok := p.ParseNext(line)
+	return ip, ok
+}
+
+func (TextParser) ParseNext(line []byte) (uint32, []byte, bool) {
+	for i := 0; i < len(line); i++ {
+		if !isDigit(line[i]) || (i > 0 && (isDigit(line[i-1]) || line[i-1] == '.')) {
+			continue
+		}
+		if ip, end, ok := matchDottedQuad(line, i); ok {
+			return ip, line[end:], true
+		}
+	}
+	return 0, nil, false
+}
+
+// Function which matches the dotted-quad IP address starting at the start index of the line
+// Returns the address and the index after it
+func matchDottedQuad(line []byte, start int) (uint32, int, bool) {
+	var ip uint32
+	pos := start
+	for segment := 0; segment < 4; segment++ {
+		if segment > 0 {
+			if pos >= len(line) || line[pos] != '.' {
+				return 0, 0, false
+			}
+			pos++
+		}
+		value, digits := uint32(0), 0
+		for pos < len(line) && isDigit(line[pos]) && digits < 4 {
+			value = value*10 + uint32(line[pos]-'0')
+			digits++
+			pos++
+		}
+		if digits == 0 || digits > 3 || value > 255 {
+			return 0, 0, false
+		}
+		ip = ip<<8 | value
+	}
+	if pos < len(line) && (isDigit(line[pos]) || (line[pos] == '.' && pos+1 < len(line) && isDigit(line[pos+1]))) {
+		return 0, 0, false
+	}
+	return ip, pos, true
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// Function which parses the hexadecimal (0x01020304) or decimal (16909060) integer form of the address
+// isInt is false when the field is not an integer at all, e.g. a dotted-quad, so other parsers can try it
+// ok is false for an integer which is not an IPv4 address (more than 8 hex digits, more than 2^32-1)
+func parseIntIp(field []byte) (ip uint32, ok bool, isInt bool) {
+	if len(field) > 2 && field[0] == '0' && (field[1] == 'x' || field[1] == 'X') {
+		digits := field[2:]
+		for _, b := range digits {
+			var v byte
+			switch {
+			case b >= '0' && b <= '9':
+				v = b - '0'
+			case b >= 'a' && b <= 'f':
+				v = b - 'a' + 10
+			case b >= 'A' && b <= 'F':
+				v = b - 'A' + 10
+			default:
+				return 0, false, true
+			}
+			ip = ip<<4 | uint32(v)
+		}
+		return ip, len(digits) <= 8, true
+	}
+
+	if len(field) == 0 || bytes.IndexByte(field, '.') >= 0 {
+		return 0, false, false
+	}
+	var value uint64
+	for _, b := range field {
+		if b < '0' || b > '9' {
+			return 0, false, false
+		}
+		value = value*10 + uint64(b-'0')
+		if value > 0xFFFFFFFF {
+			return 0, false, true
+		}
+	}
+	return uint32(value), true, true
+}
+
+// Function which returns the built-in parser for the input format name
+// The address options (strict validation, multiple notations) apply to the address field of every format
+// The delimiter separates the fields of auto, 0 for whitespace
+func newLineParser(format string, jsonKey string, delimiter byte, address DottedQuadParser) (LineParser, error) {
+	switch format {
+	case "dotted":
+		return address, nil
+	case "weblog":
+		return WebLogParser{address}, nil
+	case "jsonl":
+		return NewJSONLParser(jsonKey, address), nil
+	case "auto":
+		// the field is detected from the input file, without a file the first address of the line is used
+		return FieldParser{Field: -1, Address: address, Delimiter: delimiter}, nil
+	}
+	return nil, fmt.Errorf("unknown input format %q, expected dotted, weblog, jsonl or auto", format)
+}
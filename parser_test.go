@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestDottedQuadLengthBounds(t *testing.T) {
+	tests := []struct {
+		line string
+		ip   uint32
+		ok   bool
+	}{
+		{"0.0.0.0", 0, true},
+		{"1.1.1.1", 0x01010101, true},
+		{"255.255.255.255", 0xFFFFFFFF, true},
+		{"10.0.0.1", 0x0A000001, true},
+		{"1.1.11", 0, false},                  // 6 bytes
+		{"1.1.1.", 0, false},                  // 6 bytes
+		{"192.168.100.200", 0xC0A864C8, true}, // 15 bytes
+		{"255.255.255.2555", 0, false},        // 16 bytes, the \r of CRLF was stripped before the parser
+		{"255.255.255.255\r", 0, false},
+		{"255.255.255.255 x", 0, false}, // 17 bytes
+	}
+	for _, test := range tests {
+		for _, parser := range []DottedQuadParser{{}, {Strict: true}} {
+			ip, ok := parser.Parse([]byte(test.line))
+			if ok != test.ok || (ok && ip != test.ip) {
+				t.Errorf("%+v.Parse(%q) = %08x, %v, want %08x, %v", parser, test.line, ip, ok, test.ip, test.ok)
+			}
+		}
+	}
+}
+
+func TestDottedQuadLengthBoundsCount(t *testing.T) {
+	// the extremes count once each, the lines of 6, 16 and 17 bytes are skipped, also with CRLF endings
+	lines := []string{"0.0.0.0", "1.1.1.1", "255.255.255.255", "0.0.0.0", "1.1.11", "255.255.255.2555", "255.255.255.255 x"}
+	for _, ending := range []string{"\n", "\r\n"} {
+		path := writeTestFile(t, "input.txt", strings.Join(lines, ending)+ending)
+		result := mustCount(t, testConfig(path))
+		if result.Unique != 3 || result.Skipped != 3 {
+			t.Errorf("%q endings: unique = %d, skipped = %d, want 3 and 3", ending, result.Unique, result.Skipped)
+		}
+	}
+}
+
+func TestJSONLParserKey(t *testing.T) {
+	tests := []struct {
+		line string
+		ip   uint32
+		ok   bool
+	}{
+		{`{"ip":"1.2.3.4"}`, 0x01020304, true},
+		{`{"ip" : "1.2.3.4"}`, 0x01020304, true},
+		{"{\"ip\"\t:\t\"1.2.3.4\"}", 0x01020304, true},
+		{`{"x":"ip","ip":"1.2.3.4"}`, 0x01020304, true},
+		{`{"x":"ip", "y":"ip" ,"ip":"1.2.3.4"}`, 0x01020304, true},
+		{`{"msg":"a \"ip\": 9.9.9.9","ip":"1.2.3.4"}`, 0x01020304, true},
+		{`{"x":"ip"}`, 0, false},
+		{`{"client_ip":"1.2.3.4"}`, 0, false},
+		{`{"ip":1234}`, 0, false},
+		{`{"ip":"1.2.3.4`, 0, false},
+	}
+	parser := NewJSONLParser("ip", DottedQuadParser{})
+	for _, test := range tests {
+		ip, ok := parser.Parse([]byte(test.line))
+		if ok != test.ok || (ok && ip != test.ip) {
+			t.Errorf("Parse(%s) = %08x, %v, want %08x, %v", test.line, ip, ok, test.ip, test.ok)
+		}
+	}
+}
+
+func TestMappedPrefix(t *testing.T) {
+	tests := []struct {
+		line string
+		ip   uint32
+		ok   bool
+	}{
+		{"::ffff:192.168.0.1", 0xC0A80001, true},
+		{"::FFFF:192.168.0.1", 0xC0A80001, true},
+		{"::ffff:0.0.0.0", 0, true},
+		{"::ffff:255.255.255.255", 0xFFFFFFFF, true},
+		{"::ffff:1.2.3", 0, false},
+		// the default parser checks only the length of what follows the prefix, the other forms are rejected by Strict
+		{"::ffff:", 0, false},
+		{"::fffe:1.2.3.4", 0, false},
+		{"::1.2.3.4", 0, false},
+		{"::ffff:::ffff:1.2.3.4", 0, false},
+	}
+	for i, test := range tests {
+		for _, parser := range []DottedQuadParser{{}, {Strict: true}} {
+			if i >= 5 && !parser.Strict {
+				continue
+			}
+			ip, ok := parser.Parse([]byte(test.line))
+			if ok != test.ok || (ok && ip != test.ip) {
+				t.Errorf("%+v.Parse(%q) = %08x, %v, want %08x, %v", parser, test.line, ip, ok, test.ip, test.ok)
+			}
+		}
+	}
+}
+
+// The mapped form of an address counts once with its dotted-quad, whichever comes first
+func TestMappedMixedWithDotted(t *testing.T) {
+	lines := []string{"192.168.0.1", "::ffff:192.168.0.1", "::ffff:10.0.0.1", "10.0.0.1", "::FFFF:10.0.0.2", "::ffff:10.0.0.2", "::ffff:10.0"}
+	path := writeTestFile(t, "input.txt", strings.Join(lines, "\n")+"\r\n")
+	result := mustCount(t, testConfig(path))
+	if result.Unique != 3 || result.Skipped != 1 {
+		t.Errorf("unique = %d, skipped = %d, want 3 and 1", result.Unique, result.Skipped)
+	}
+}
+
+func TestMultiFormatNotations(t *testing.T) {
+	tests := []struct {
+		line string
+		ip   uint32
+		ok   bool
+	}{
+		{"1.2.3.4", 0x01020304, true},
+		{"0x01020304", 0x01020304, true},
+		{"0X01020304", 0x01020304, true},
+		{"0x1020304", 0x01020304, true},
+		{"16909060", 0x01020304, true},
+		{"0", 0, true},
+		{"4294967295", 0xFFFFFFFF, true},
+		{"0xffffffff", 0xFFFFFFFF, true},
+		{"4294967296", 0, false},
+		{"0x100000000", 0, false},
+		{"0x0102030g", 0, false},
+	}
+	parser := DottedQuadParser{MultiFormat: true}
+	for _, test := range tests {
+		ip, ok := parser.Parse([]byte(test.line))
+		if ok != test.ok || (ok && ip != test.ip) {
+			t.Errorf("Parse(%q) = %08x, %v, want %08x, %v", test.line, ip, ok, test.ip, test.ok)
+		}
+	}
+}
+
+// The three notations of one address count once, the other address of the file once more
+func TestMultiFormatCountsOnce(t *testing.T) {
+	lines := []string{"1.2.3.4", "0x01020304", "16909060", "::ffff:1.2.3.4", "0x0a000001", "10.0.0.1", "167772161"}
+	path := writeTestFile(t, "input.txt", strings.Join(lines, "\n")+"\n")
+	config := testConfig(path)
+	config.parser = DottedQuadParser{MultiFormat: true}
+	if result := mustCount(t, config); result.Unique != 2 || result.Skipped != 0 {
+		t.Errorf("unique = %d, skipped = %d, want 2 and 0", result.Unique, result.Skipped)
+	}
+}
+
+// Per-byte loop against the fixed-width fast path over addresses of all lengths (7 to 15 bytes)
+func BenchmarkBytesLineToUint32(b *testing.B) {
+	lines := make([][]byte, 1024)
+	for i, ip := range randomIps(len(lines), 4) {
+		if i%4 == 0 {
+			ip &= 0x0F0F0F0F // short segments, down to 0.0.0.0
+		}
+		lines[i] = []byte(netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)}).String())
+	}
+	for _, parse := range []struct {
+		name string
+		fn   func([]byte) uint32
+	}{{"loop", bytesLineToUint32}, {"fast", bytesLineToUint32Fast}} {
+		b.Run(parse.name, func(b *testing.B) {
+			var sum uint32
+			for i := 0; i < b.N; i++ {
+				sum += parse.fn(lines[i&(len(lines)-1)])
+			}
+			if sum == 1 {
+				b.Log(sum)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// Function which prints the work plan of -plan without reading the input: the size and the chunks of every file,
+// the byte range every chunk owns, the number of reading threads, the read buffers and the set
+// A chunk owns the lines which start in its [start, end) range, the last one is read past end up to its newline,
+// so the ranges are the split itself and the overlap is at most one line per chunk
+func printPlan(w io.Writer, config Config) error {
+	files, err := inputFiles(config.filePaths, config.binary)
+	if err != nil {
+		return err
+	}
+
+	chunkCount := 0
+	fileThreads := 1 // Threads of the file with the most chunks, the pool of -count-per-file
+	for _, file := range files {
+		jobs := splitJobs(config, []inputFile{file})
+		chunkCount += len(jobs)
+		switch {
+		case file.compression != "":
+			fmt.Fprintf(w, "File %s: size = %d, %s compressed, read as one stream by one thread\n", file.path, file.size, file.compression)
+			continue
+		case len(jobs) == 0:
+			fmt.Fprintf(w, "File %s: size = 0, nothing to read\n", file.path)
+			continue
+		}
+		fmt.Fprintf(w, "File %s: size = %d, chunks = %d, bytes per chunk = %d", file.path, file.size, len(jobs), jobs[0].length)
+		if config.countPerFile {
+			// the files are read one by one, each by its own pool of threads
+			fileThreads = max(fileThreads, min(config.numThreads, len(jobs)))
+			fmt.Fprintf(w, ", threads = %d", min(config.numThreads, len(jobs)))
+		}
+		fmt.Fprintln(w)
+		for i, job := range jobs {
+			fmt.Fprintf(w, "  Chunk %d: [%d, %d)\n", i, job.offset, min(job.offset+int64(job.length), file.size))
+		}
+	}
+
+	threads := max(1, min(config.numThreads, chunkCount))
+	if config.countPerFile {
+		threads = fileThreads
+	}
+	fmt.Fprintf(w, "Reading threads = %d of -t %d\n", threads, config.numThreads)
+	fmt.Fprintf(w, "Read buffers = %d bytes per thread (line reader and line buffer of %d each), %d in total\n", 2*BUFFER_SIZE, BUFFER_SIZE, threads*2*BUFFER_SIZE)
+
+	backend := config.backend
+	if backend == "auto" {
+		if backend, err = chooseBackend(config); err != nil {
+			return err
+		}
+	}
+	if backend == "array" {
+		fmt.Fprintf(w, "Set = array of %d bytes\n", bitsetWords(config.networkBits)*4)
+	} else {
+		fmt.Fprintf(w, "Set = %s, grows with the input\n", backend)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress event written by -progress-json, one JSON object per line
+type progressEvent struct {
+	Bytes     uint64 `json:"bytes"`      // Bytes of the input files processed so far
+	Total     int64  `json:"total"`      // Size of all input files
+	Unique    uint64 `json:"unique"`     // Unique count so far, exact in the final event
+	ElapsedMs int64  `json:"elapsed_ms"` // Time since the start of reading
+	Done      bool   `json:"done"`       // Set in the final event written after all workers finished
+}
+
+// Function which opens the destination of the progress events
+// "fd:N" writes to the already open file descriptor N (e.g. a pipe of the supervising process),
+// anything else is a path of the file which is created
+func openProgressOutput(dest string) (io.WriteCloser, error) {
+	if fdText, ok := strings.CutPrefix(dest, "fd:"); ok {
+		fd, err := strconv.Atoi(fdText)
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("invalid progress file descriptor %q", dest)
+		}
+		return os.NewFile(uintptr(fd), dest), nil
+	}
+	return os.Create(dest)
+}
+
+// Function which writes a progress event every interval while the workers are reading
+// The bytes come from the processedBytes counter of the workers and the unique count is a live
+// snapshot (CountApprox), the event written by the returned stop function has the exact final count
+func reportProgressJson(dest string, interval time.Duration, total int64) (func(), error) {
+	output, err := openProgressOutput(dest)
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(output)
+	start := time.Now()
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				encoder.Encode(progressEvent{
+					Bytes:     processedBytes.Load(),
+					Total:     total,
+					Unique:    ips.CountApprox(),
+					ElapsedMs: time.Since(start).Milliseconds(),
+				})
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+		encoder.Encode(progressEvent{
+			Bytes:     processedBytes.Load(),
+			Total:     total,
+			Unique:    ips.Count(),
+			ElapsedMs: time.Since(start).Milliseconds(),
+			Done:      true,
+		})
+		output.Close()
+	}, nil
+}
+
+// Function which prints the live unique count (CountApprox) on its own line every interval for -watch,
+// the returned stop function prints the exact count once the workers finished
+func watchCount(w io.Writer, interval time.Duration) func() {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Fprintln(w, ips.CountApprox())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+		fmt.Fprintln(w, ips.Count())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+)
+
+const LARGE_RANGE_WARN = 1 << 24 // Ranges of a /8 or more are logged when they're expanded
+
+// Parser of the lines which hold an inclusive range of IP addresses, "10.0.0.0-10.0.0.255"
+// Spaces around the dash are allowed, both ends are parsed by Address with its options
+type RangeParser struct {
+	Address DottedQuadParser
+}
+
+// Parse returns the first address of the range, every address is counted through ParseRange
+func (p RangeParser) Parse(line []byte) (uint32, bool) {
+	first, _, ok := p.ParseRange(line)
+	return first, ok
+}
+
+func (p RangeParser) ParseRange(line []byte) (uint32, uint32, bool) {
+	firstText, lastText, found := bytes.Cut(line, []byte{'-'})
+	if !found {
+		return 0, 0, false
+	}
+	first, ok := p.Address.Parse(bytes.TrimSpace(firstText))
+	if !ok {
+		return 0, 0, false
+	}
+	last, ok := p.Address.Parse(bytes.TrimSpace(lastText))
+	if !ok || last < first {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// Parser of the lines which hold a network in CIDR notation, "10.0.0.0/24"
+// The host bits of the address are cleared like netip.Prefix.Masked, a line without a prefix length is a single address
+type CIDRParser struct {
+	Address DottedQuadParser
+}
+
+// Parse returns the first address of the network, every address is counted through ParseRange
+func (p CIDRParser) Parse(line []byte) (uint32, bool) {
+	first, _, ok := p.ParseRange(line)
+	return first, ok
+}
+
+func (p CIDRParser) ParseRange(line []byte) (uint32, uint32, bool) {
+	addressText, bitsText, found := bytes.Cut(line, []byte{'/'})
+	ip, ok := p.Address.Parse(addressText)
+	if !ok {
+		return 0, 0, false
+	}
+	if !found {
+		return ip, ip, true
+	}
+	if len(bitsText) == 0 || len(bitsText) > 2 {
+		return 0, 0, false
+	}
+	prefixBits := 0
+	for _, b := range bitsText {
+		if !isDigit(b) {
+			return 0, 0, false
+		}
+		prefixBits = prefixBits*10 + int(b-'0')
+	}
+	if prefixBits > 32 {
+		return 0, 0, false
+	}
+	first, last := prefixRange(ip, prefixBits)
+	return first, last, true
+}
+
+// Function which returns the first and the last address of the network of the address with the prefix length
+func prefixRange(ip uint32, prefixBits int) (uint32, uint32) {
+	hostMask := uint32(uint64(1)<<(32-prefixBits) - 1)
+	return ip &^ hostMask, ip | hostMask
+}
+
+// Function which adds every address of the inclusive range, the range isn't added when it's
+// larger than -max-range and the line is counted as skipped
+// Ranges of a /8 or more take a noticeable time and are usually a mistake, so they're logged
+func addRange(first uint32, last uint32, networkShift uint) bool {
+	size := uint64(last-first) + 1
+	if size > maxRange {
+		oversizedRanges.Add(1)
+		return false
+	}
+	if size >= LARGE_RANGE_WARN {
+		slog.Warn("expanding a large range", "first", string(appendDottedIp(nil, first)), "last", string(appendDottedIp(nil, last)), "addresses", size)
+	}
+	for ip := first; ; ip++ {
+		addIp(ip, networkShift)
+		if ip == last {
+			break
+		}
+	}
+	expandedAddresses.Add(size)
+	return true
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Unique count of one input file read with -count-per-file
+type FileResult struct {
+	Path   string `json:"path"`   // Path of the input file
+	Unique uint64 `json:"unique"` // Unique IPs of the file alone
+	New    uint64 `json:"new"`    // Unique IPs the file added to the previous files
+}
+
+// Result of a run, printed as the text summary or as JSON with -o json
+type Result struct {
+	Unique         uint64           // Unique IPs (or networks with -network-bits) of the whole input
+	Approx         bool             // Unique is a HyperLogLog estimate (-approx)
+	Human          bool             // The unique count is followed by its readable forms (-human)
+	Files          []FileResult     // Counts of the files in order, only with -count-per-file
+	ParseOnly      bool             // The lines were only parsed (-parse-only), Unique is not counted
+	Parsed         uint64           // Lines with an IP address, only with -parse-only
+	ParseRate      float64          // Parsed lines per second, only with -parse-only
+	Allowlist      *uint64          // Number of the allowlisted IPs, nil without -only-file
+	MinOccurrences int              // Threshold of -min-occurrences, 0 when the frequent IPs are not counted
+	Frequent       uint64           // IPs seen at least MinOccurrences times
+	DupWindow      int              // Size of -dup-window, 0 when the repeats are not counted
+	Repeats        uint64           // Addresses already present in the window
+	Gaps           string           // CIDR range of -gaps, empty when not set
+	Missing        uint64           // Addresses of the Gaps range absent from the input
+	Expanded       *uint64          // Addresses of the expanded ranges, nil without -ranges
+	Oversized      uint64           // Ranges skipped because they are larger than -max-range, also counted in Skipped
+	Records        *uint64          // Whole 4-byte records of the -binary input, nil without -binary
+	Skipped        uint64           // Lines without a valid IP address
+	FreeText       bool             // The lines were searched for IPs (-regex), Skipped are the lines without any
+	Octets         *[4][256]uint64  // Unique IPs with each value of each octet, nil without -octet-distribution
+	Classes        *[5]uint64       // Unique IPs of the classes A to E, nil without -ipv4-classes
+	Prefixes       []PrefixCount    // Distinct networks of every prefix length, nil without -count-distinct-per-prefix-length
+	Baseline       *BaselineChange  // Addresses added and removed since the -baseline dump, nil without -baseline
+	Asns           *AsnReport       // Unique IPs of the autonomous systems with the most of them, nil without -asn-db
+	Geo            *GeoReport       // Unique IPs of every country, nil without -geo-db
+	Collisions     *CollisionReport // Different lines parsed to the same address, nil without -count-collisions
+	SpecialUse     []CategoryCount  // Unique IPs of the special-use categories and the public ones, nil without -count-reserved-separately
+}
+
+// Function which formats the human readable summary, one line per reported number
+func (r Result) String() string {
+	var b strings.Builder
+	for _, file := range r.Files {
+		fmt.Fprintf(&b, "File %s: unique = %d, new = %d\n", file.Path, file.Unique, file.New)
+	}
+	if r.ParseOnly {
+		fmt.Fprintln(&b, "Parsed lines =", r.Parsed)
+		fmt.Fprintf(&b, "Parse rate = %.0f lines/s\n", r.ParseRate)
+	} else {
+		if r.Approx {
+			fmt.Fprint(&b, "Approximate unique ip count = ", r.Unique)
+		} else {
+			fmt.Fprint(&b, "Unique ip count = ", r.Unique)
+		}
+		if r.Human && r.Unique >= 1000 {
+			fmt.Fprintf(&b, " (%s / %s)", groupDigits(r.Unique), siCount(r.Unique))
+		}
+		b.WriteByte('\n')
+	}
+	if r.Allowlist != nil {
+		fmt.Fprintf(&b, "Allowlisted ips seen = %d of %d\n", r.Unique, *r.Allowlist)
+	}
+	if r.MinOccurrences > 0 {
+		fmt.Fprintf(&b, "Ips seen at least %d times = %d\n", r.MinOccurrences, r.Frequent)
+	}
+	if r.Gaps != "" {
+		fmt.Fprintf(&b, "Missing ips in %s = %d\n", r.Gaps, r.Missing)
+	}
+	if r.DupWindow > 0 {
+		fmt.Fprintf(&b, "Repeats within %d addresses = %d\n", r.DupWindow, r.Repeats)
+	}
+	if r.Expanded != nil {
+		fmt.Fprintln(&b, "Expanded addresses =", *r.Expanded)
+	}
+	if r.Records != nil {
+		fmt.Fprintln(&b, "Binary records =", *r.Records)
+	}
+	if r.Octets != nil {
+		for position, histogram := range r.Octets {
+			fmt.Fprintf(&b, "Octet %d distribution =", position+1)
+			for value, count := range histogram {
+				if count > 0 {
+					fmt.Fprintf(&b, " %d:%d", value, count)
+				}
+			}
+			b.WriteByte('\n')
+		}
+	}
+	if r.Classes != nil {
+		fmt.Fprintf(&b, "Ipv4 classes = A:%d B:%d C:%d D:%d E:%d\n", r.Classes[0], r.Classes[1], r.Classes[2], r.Classes[3], r.Classes[4])
+	}
+	for _, prefix := range r.Prefixes {
+		fmt.Fprintf(&b, "Distinct /%d networks = %d\n", prefix.Bits, prefix.Unique)
+	}
+	if r.SpecialUse != nil {
+		b.WriteString("Special-use ips =")
+		for _, category := range r.SpecialUse {
+			fmt.Fprintf(&b, " %s:%d", category.Category, category.Count)
+		}
+		b.WriteByte('\n')
+	}
+	if r.Baseline != nil {
+		fmt.Fprintln(&b, "Added since baseline =", r.Baseline.Added)
+		fmt.Fprintln(&b, "Removed since baseline =", r.Baseline.Removed)
+	}
+	if r.Asns != nil {
+		for _, system := range r.Asns.Top {
+			fmt.Fprintf(&b, "AS%d %s = %d\n", system.Asn, system.Organization, system.Count)
+		}
+		fmt.Fprintln(&b, "Autonomous systems =", r.Asns.Distinct)
+		fmt.Fprintln(&b, "Ips without autonomous system =", r.Asns.Unknown)
+	}
+	if r.Geo != nil {
+		for _, country := range r.Geo.Countries {
+			fmt.Fprintf(&b, "Country %s = %d\n", country.Country, country.Count)
+		}
+		fmt.Fprintln(&b, "Ips without country =", r.Geo.Unmatched)
+	}
+	if r.Collisions != nil {
+		fmt.Fprintf(&b, "Parser collisions = %d in %d sampled lines\n", r.Collisions.Count, r.Collisions.Sampled)
+		for _, collision := range r.Collisions.Examples {
+			fmt.Fprintf(&b, "Collision %s: %q and %q\n", collision.Ip, collision.First, collision.Second)
+		}
+	}
+	if r.Skipped > 0 {
+		if r.FreeText {
+			fmt.Fprintln(&b, "Lines without ips =", r.Skipped)
+		} else {
+			fmt.Fprintln(&b, "Skipped lines =", r.Skipped)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Function which formats the count with a comma between every group of three digits, e.g. 1,234,567
+func groupDigits(n uint64) string {
+	digits := strconv.FormatUint(n, 10)
+	var b strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	return b.String()
+}
+
+// Function which formats the count with three significant digits and an SI suffix, e.g. 1.23M
+func siCount(n uint64) string {
+	value := float64(n)
+	suffix := ""
+	// the limits are the values which round up to the next width, 999.7k is printed as 1.00M
+	for _, unit := range []string{"k", "M", "G"} {
+		if value < 999.5 {
+			break
+		}
+		value /= 1000
+		suffix = unit
+	}
+	switch {
+	case suffix == "":
+		return strconv.FormatUint(n, 10)
+	case value < 9.995:
+		return fmt.Sprintf("%.2f%s", value, suffix)
+	case value < 99.95:
+		return fmt.Sprintf("%.1f%s", value, suffix)
+	}
+	return fmt.Sprintf("%.0f%s", value, suffix)
+}
+
+// Function which encodes the result as one JSON object for -o json
+// Only the numbers of the enabled features are present, like the lines of the text summary
+func (r Result) MarshalJSON() ([]byte, error) {
+	type allowlist struct {
+		Seen  uint64 `json:"seen"`
+		Total uint64 `json:"total"`
+	}
+	type frequent struct {
+		MinOccurrences int    `json:"min_occurrences"`
+		Count          uint64 `json:"count"`
+	}
+	type gaps struct {
+		Range   string `json:"range"`
+		Missing uint64 `json:"missing"`
+	}
+	type classes struct {
+		A uint64 `json:"A"`
+		B uint64 `json:"B"`
+		C uint64 `json:"C"`
+		D uint64 `json:"D"`
+		E uint64 `json:"E"`
+	}
+	type repeats struct {
+		Window int    `json:"window"`
+		Count  uint64 `json:"count"`
+	}
+	out := struct {
+		Unique       *uint64          `json:"unique,omitempty"`
+		Approximate  bool             `json:"approximate,omitempty"`
+		ParsedLines  *uint64          `json:"parsed_lines,omitempty"`
+		ParseRate    *float64         `json:"parse_rate,omitempty"`
+		Files        []FileResult     `json:"files,omitempty"`
+		Allowlist    *allowlist       `json:"allowlist,omitempty"`
+		Frequent     *frequent        `json:"frequent,omitempty"`
+		Gaps         *gaps            `json:"gaps,omitempty"`
+		Repeats      *repeats         `json:"repeats,omitempty"`
+		Octets       *[4][256]uint64  `json:"octets,omitempty"`
+		Classes      *classes         `json:"classes,omitempty"`
+		Prefixes     []PrefixCount    `json:"prefix_counts,omitempty"`
+		SpecialUse   []CategoryCount  `json:"special_use,omitempty"`
+		Baseline     *BaselineChange  `json:"baseline,omitempty"`
+		Asns         *AsnReport       `json:"asns,omitempty"`
+		Geo          *GeoReport       `json:"geo,omitempty"`
+		Collisions   *CollisionReport `json:"collisions,omitempty"`
+		Expanded     *uint64          `json:"expanded_addresses,omitempty"`
+		Oversized    uint64           `json:"oversized_ranges,omitempty"`
+		Records      *uint64          `json:"records,omitempty"`
+		SkippedLines uint64           `json:"skipped_lines"`
+	}{Approximate: r.Approx, Files: r.Files, Octets: r.Octets, Prefixes: r.Prefixes, SpecialUse: r.SpecialUse, Baseline: r.Baseline, Asns: r.Asns, Geo: r.Geo, Collisions: r.Collisions, Expanded: r.Expanded, Oversized: r.Oversized, Records: r.Records, SkippedLines: r.Skipped}
+
+	if r.ParseOnly {
+		rate := float64(uint64(r.ParseRate))
+		out.ParsedLines, out.ParseRate = &r.Parsed, &rate
+	} else {
+		out.Unique = &r.Unique
+	}
+	if r.Allowlist != nil {
+		out.Allowlist = &allowlist{Seen: r.Unique, Total: *r.Allowlist}
+	}
+	if r.MinOccurrences > 0 {
+		out.Frequent = &frequent{MinOccurrences: r.MinOccurrences, Count: r.Frequent}
+	}
+	if r.Gaps != "" {
+		out.Gaps = &gaps{Range: r.Gaps, Missing: r.Missing}
+	}
+	if r.Classes != nil {
+		out.Classes = &classes{A: r.Classes[0], B: r.Classes[1], C: r.Classes[2], D: r.Classes[3], E: r.Classes[4]}
+	}
+	if r.DupWindow > 0 {
+		out.Repeats = &repeats{Window: r.DupWindow, Count: r.Repeats}
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Function which returns a result with most of the reported numbers set
+func populatedResult() Result {
+	allowlist, expanded, records := uint64(50), uint64(1024), uint64(2000)
+	return Result{
+		Unique:         1234567,
+		Human:          true,
+		Files:          []FileResult{{Path: "a.txt", Unique: 1000000, New: 1000000}, {Path: "b.txt", Unique: 300000, New: 234567}},
+		Allowlist:      &allowlist,
+		MinOccurrences: 3,
+		Frequent:       42,
+		DupWindow:      100,
+		Repeats:        7,
+		Gaps:           "10.0.0.0/24",
+		Missing:        200,
+		Expanded:       &expanded,
+		Records:        &records,
+		Classes:        &[5]uint64{1, 2, 3, 4, 5},
+		Prefixes:       []PrefixCount{{Bits: 8, Unique: 3}, {Bits: 16, Unique: 9}},
+		SpecialUse:     []CategoryCount{{Category: "private", Count: 10}, {Category: "public", Count: 20}},
+		Baseline:       &BaselineChange{Added: 11, Removed: 12},
+		Skipped:        5,
+	}
+}
+
+func TestResultString(t *testing.T) {
+	tests := []struct {
+		name   string
+		result Result
+		want   string
+	}{
+		{"plain", Result{Unique: 3}, "Unique ip count = 3"},
+		{"approximate with skipped lines", Result{Unique: 3, Approx: true, Skipped: 2}, "Approximate unique ip count = 3\nSkipped lines = 2"},
+		{"free text", Result{Unique: 3, FreeText: true, Skipped: 2}, "Unique ip count = 3\nLines without ips = 2"},
+		{"parse only", Result{ParseOnly: true, Parsed: 10, ParseRate: 1234.6}, "Parsed lines = 10\nParse rate = 1235 lines/s"},
+		{"populated", populatedResult(), `File a.txt: unique = 1000000, new = 1000000
+File b.txt: unique = 300000, new = 234567
+Unique ip count = 1234567 (1,234,567 / 1.23M)
+Allowlisted ips seen = 1234567 of 50
+Ips seen at least 3 times = 42
+Missing ips in 10.0.0.0/24 = 200
+Repeats within 100 addresses = 7
+Expanded addresses = 1024
+Binary records = 2000
+Ipv4 classes = A:1 B:2 C:3 D:4 E:5
+Distinct /8 networks = 3
+Distinct /16 networks = 9
+Special-use ips = private:10 public:20
+Added since baseline = 11
+Removed since baseline = 12
+Skipped lines = 5`},
+	}
+	for _, test := range tests {
+		if got := test.result.String(); got != test.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestResultMarshalJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		result Result
+		want   string
+	}{
+		{"plain", Result{Unique: 3}, `{"unique":3,"skipped_lines":0}`},
+		{"approximate", Result{Unique: 3, Approx: true, Skipped: 2}, `{"unique":3,"approximate":true,"skipped_lines":2}`},
+		{"parse only", Result{ParseOnly: true, Parsed: 10, ParseRate: 1234.6}, `{"parsed_lines":10,"parse_rate":1234,"skipped_lines":0}`},
+		{"populated", populatedResult(), `{"unique":1234567,` +
+			`"files":[{"path":"a.txt","unique":1000000,"new":1000000},{"path":"b.txt","unique":300000,"new":234567}],` +
+			`"allowlist":{"seen":1234567,"total":50},"frequent":{"min_occurrences":3,"count":42},` +
+			`"gaps":{"range":"10.0.0.0/24","missing":200},"repeats":{"window":100,"count":7},` +
+			`"classes":{"A":1,"B":2,"C":3,"D":4,"E":5},"prefix_counts":[{"bits":8,"unique":3},{"bits":16,"unique":9}],` +
+			`"special_use":[{"category":"private","count":10},{"category":"public","count":20}],` +
+			`"baseline":{"added":11,"removed":12},"expanded_addresses":1024,"records":2000,"skipped_lines":5}`},
+	}
+	for _, test := range tests {
+		got, err := json.Marshal(test.result)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if string(got) != test.want {
+			t.Errorf("%s:\n%s\nwant:\n%s", test.name, got, test.want)
+		}
+	}
+}
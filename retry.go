@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+const (
+	READ_RETRY_BACKOFF = 100 * time.Millisecond // Delay before the first read retry, doubled on every next attempt
+	SHORT_READ_RETRIES = 3                      // Retries of -retry-on-short-read when -read-retries is lower
+	READ_SKIP_BYTES    = 4096                   // Bytes skipped after a failed read with -skip-read-errors, one page or disk block
+)
+
+// Reader of a file which survives transient errors of network filesystems (EIO, ESTALE)
+// When opening, seeking or reading fails, the file is reopened and read again from the same position
+// after a growing delay, the error is returned only after retries failed attempts
+// With shortReads the position after the seek is verified and the first read after it is filled:
+// a read which returns less than asked is continued, one which returns nothing is retried
+type retryReader struct {
+	path       string            // Path to the file
+	file       io.ReadSeekCloser // Currently open file, nil after a failed reopen
+	pos        int64             // Position of the next byte to read
+	retries    int               // Number of retries of every failed operation (0 = fail immediately)
+	shortReads bool              // Verify the seek and fill the first read (-retry-on-short-read)
+	filled     bool              // The first read was already filled
+	open       fileOpener        // Opener of the file, openFile outside of the tests
+}
+
+// Opener of the file of a retryReader, the tests inject failures with their own
+type fileOpener func(path string) (io.ReadSeekCloser, error)
+
+// Function which opens the file of the filesystem
+func openFile(path string) (io.ReadSeekCloser, error) {
+	return os.Open(path)
+}
+
+// Function which opens the file at the given position, retrying with backoff
+func openRetryReader(path string, pos int64, retries int, shortReads bool) (*retryReader, error) {
+	return newRetryReader(path, pos, retries, shortReads, openFile)
+}
+
+// Function which opens the file at the given position with the opener, retrying with backoff
+func newRetryReader(path string, pos int64, retries int, shortReads bool, open fileOpener) (*retryReader, error) {
+	if shortReads {
+		retries = max(retries, SHORT_READ_RETRIES)
+	}
+	r := &retryReader{path: path, pos: pos, retries: retries, shortReads: shortReads, open: open}
+	err := r.reopen()
+	for attempt := 1; err != nil && attempt <= retries; attempt++ {
+		err = r.retry(attempt, err)
+	}
+	if err != nil {
+		return nil, r.giveUp(err)
+	}
+	return r, nil
+}
+
+// Function which (re)opens the file and seeks to the current position
+func (r *retryReader) reopen() error {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	file, err := r.open(r.path)
+	if err != nil {
+		return err
+	}
+	landed, err := file.Seek(r.pos, io.SeekStart)
+	if err == nil && r.shortReads && landed != r.pos {
+		err = fmt.Errorf("seek of %s landed at %d instead of %d", r.path, landed, r.pos)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	return nil
+}
+
+// Function which waits for the backoff of the attempt and reopens the file
+func (r *retryReader) retry(attempt int, err error) error {
+	slog.Warn("file access failed, retrying", "file", r.path, "position", r.pos, "attempt", attempt, "err", err)
+	time.Sleep(READ_RETRY_BACKOFF << (attempt - 1))
+	return r.reopen()
+}
+
+// Function which wraps the final error once all the retries are used up
+func (r *retryReader) giveUp(err error) error {
+	if r.retries == 0 {
+		return err
+	}
+	return fmt.Errorf("giving up after %d retries: %w", r.retries, err)
+}
+
+// Read returns the data of the file, a failed read is retried from the same position
+// When some bytes were read together with an error they are returned first,
+// the error shows up again on the next read and is retried then
+func (r *retryReader) Read(p []byte) (int, error) {
+	if r.shortReads && !r.filled {
+		r.filled = true
+		return r.readFirst(p)
+	}
+	return r.read(p)
+}
+
+// Function which fills p with the first read after the seek, a network filesystem may return
+// less than asked for, or nothing without an error, right after the seek to the middle of a block
+// Reads which return nothing are retried by reopening the file at the same position
+func (r *retryReader) readFirst(p []byte) (int, error) {
+	n := 0
+	for empty := 0; n < len(p); {
+		m, err := r.read(p[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if m > 0 {
+			continue
+		}
+		empty++
+		if empty > r.retries {
+			return n, r.giveUp(io.ErrNoProgress)
+		}
+		if err := r.retry(empty, io.ErrNoProgress); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (r *retryReader) read(p []byte) (int, error) {
+	var n int
+	var err error
+	for attempt := 0; attempt <= r.retries; attempt++ {
+		if attempt > 0 {
+			if err = r.retry(attempt, err); err != nil {
+				continue
+			}
+		}
+		n, err = r.file.Read(p)
+		r.pos += int64(n)
+		if n > 0 && err != io.EOF {
+			return n, nil
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+	}
+	return n, r.giveUp(err)
+}
+
+func (r *retryReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// Opener whose files fail the first opens and reads with EIO, like a network filesystem with
+// transient errors; the failures left are shared by all the files it opened
+type flakyOpener struct {
+	openFailures int
+	readFailures int
+	opens        int
+}
+
+func (o *flakyOpener) open(path string) (io.ReadSeekCloser, error) {
+	o.opens++
+	if o.openFailures > 0 {
+		o.openFailures--
+		return nil, &os.PathError{Op: "open", Path: path, Err: syscall.EIO}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &flakyFile{File: file, opener: o}, nil
+}
+
+// File which fails every read with EIO while its opener has read failures left
+type flakyFile struct {
+	*os.File
+	opener *flakyOpener
+}
+
+func (f *flakyFile) Read(p []byte) (int, error) {
+	if f.opener.readFailures > 0 {
+		f.opener.readFailures--
+		return 0, &os.PathError{Op: "read", Path: f.Name(), Err: syscall.EIO}
+	}
+	return f.File.Read(p)
+}
+
+// Function which reads the file from pos to the end through a retryReader with the opener
+func readWithRetries(path string, pos int64, retries int, opener *flakyOpener) (string, error) {
+	reader, err := newRetryReader(path, pos, retries, false, opener.open)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	return string(content), err
+}
+
+// Opens and reads failing K times are retried from the same position while K <= -read-retries,
+// after that the last error is returned, wrapped with the number of retries
+func TestRetryReaderTransientFailures(t *testing.T) {
+	content := strings.Join(ipLines(1000), "\n") + "\n"
+	path := writeTestFile(t, "input.txt", content)
+	tests := []struct {
+		name         string
+		openFailures int
+		readFailures int
+		retries      int
+		fail         bool
+	}{
+		{"no failure", 0, 0, 0, false},
+		{"open fails twice", 2, 0, 2, false},
+		{"reads fail twice", 0, 2, 2, false},
+		{"open and read fail", 1, 1, 1, false},
+		{"open fails past the retries", 3, 0, 2, true},
+		{"reads fail past the retries", 0, 2, 1, true},
+		{"no retries", 0, 1, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, pos := range []int64{0, 1234} {
+				opener := &flakyOpener{openFailures: test.openFailures, readFailures: test.readFailures}
+				got, err := readWithRetries(path, pos, test.retries, opener)
+				if test.fail {
+					if !errors.Is(err, syscall.EIO) {
+						t.Fatalf("pos %d: error %v, want EIO", pos, err)
+					}
+					if test.retries > 0 && !strings.Contains(err.Error(), "giving up after") {
+						t.Errorf("pos %d: error %v doesn't name the retries", pos, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("pos %d: %v", pos, err)
+				}
+				if got != content[pos:] {
+					t.Errorf("pos %d: read %d bytes which differ from the %d of the file", pos, len(got), len(content[pos:]))
+				}
+				if want := 1 + test.openFailures + test.readFailures; opener.opens != want {
+					t.Errorf("pos %d: %d opens, want %d", pos, opener.opens, want)
+				}
+			}
+		})
+	}
+}
+
+// Opener whose files return at most chunk bytes per read, like a network filesystem right after a seek
+// to the middle of a block; the first seeks land a byte early and the first reads return nothing
+type shortOpener struct {
+	chunk       int
+	missedSeeks int
+	emptyReads  int
+	opens       int
+}
+
+func (o *shortOpener) open(path string) (io.ReadSeekCloser, error) {
+	o.opens++
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &shortFile{File: file, opener: o}, nil
+}
+
+// File of a shortOpener
+type shortFile struct {
+	*os.File
+	opener *shortOpener
+}
+
+func (f *shortFile) Seek(offset int64, whence int) (int64, error) {
+	if f.opener.missedSeeks > 0 && offset > 0 {
+		f.opener.missedSeeks--
+		offset--
+	}
+	return f.File.Seek(offset, whence)
+}
+
+func (f *shortFile) Read(p []byte) (int, error) {
+	if f.opener.emptyReads > 0 {
+		f.opener.emptyReads--
+		return 0, nil
+	}
+	return f.File.Read(p[:min(len(p), f.opener.chunk)])
+}
+
+// With -retry-on-short-read the first read after the seek is filled from short reads, reads which return
+// nothing and seeks which land elsewhere are retried by reopening the file, and the reader gives up
+// with io.ErrNoProgress once the retries are used up
+func TestRetryReaderShortReads(t *testing.T) {
+	content := strings.Join(ipLines(1000), "\n") + "\n"
+	path := writeTestFile(t, "input.txt", content)
+	const pos = 1234
+	tests := []struct {
+		name        string
+		missedSeeks int
+		emptyReads  int
+		opens       int
+		err         string
+	}{
+		{"short reads", 0, 0, 1, ""},
+		{"empty reads", 0, 2, 3, ""},
+		{"seek lands early", 1, 0, 2, ""},
+		{"empty reads past the retries", 0, SHORT_READ_RETRIES + 1, 0, io.ErrNoProgress.Error()},
+		{"seeks land early past the retries", SHORT_READ_RETRIES + 1, 0, 0, "landed at 1233 instead of 1234"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opener := &shortOpener{chunk: 7, missedSeeks: test.missedSeeks, emptyReads: test.emptyReads}
+			reader, err := newRetryReader(path, pos, 0, true, opener.open)
+			if err == nil {
+				defer reader.Close()
+				first := make([]byte, 4096)
+				var n int
+				n, err = reader.Read(first)
+				if err == nil {
+					if n != len(first) || string(first) != content[pos:pos+len(first)] {
+						t.Fatalf("first read of %d bytes differs from the file", n)
+					}
+					var rest []byte
+					rest, err = io.ReadAll(reader)
+					if err == nil && string(first)+string(rest) != content[pos:] {
+						t.Errorf("read %d bytes which differ from the %d of the file", n+len(rest), len(content[pos:]))
+					}
+				}
+			}
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) || !strings.Contains(err.Error(), "giving up after") {
+					t.Errorf("error %v, want %q after the retries", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opener.opens != test.opens {
+				t.Errorf("%d opens, want %d", opener.opens, test.opens)
+			}
+		})
+	}
+
+	// without the flag the short read is returned as it is
+	opener := &shortOpener{chunk: 7}
+	reader, err := newRetryReader(path, pos, 0, false, opener.open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if n, err := reader.Read(make([]byte, 4096)); n != 7 || err != nil {
+		t.Errorf("read %d bytes, %v without -retry-on-short-read, want 7", n, err)
+	}
+}
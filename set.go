@@ -0,0 +1,288 @@
+package main
+
+import (
+	"math/bits"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"Lightspeed_Task/ipcount"
+)
+
+const (
+	ROARING_ARRAY_MAX = 4096 // Addresses of a /16 kept in a sorted array (8KB), more are converted to a bitmap
+	HASH_SHARDS       = 256  // Independently locked maps of the hash set
+)
+
+// Set of the IP addresses (or networks) filled by the workers, defined by the library next to its
+// sparse set; the sets below implement it as well
+type Set = ipcount.Set
+
+// Set which reports whether Add set a new address, for writing the addresses while reading
+// (-count-unique-and-write-in-one-pass). The test and the set are one atomic OR, so of the workers
+// adding the same address at the same time exactly one sees it as new
+type newAdder interface {
+	AddNew(ip uint32) bool
+}
+
+// Set which stores one bit per address in a flat uint32 array
+// The full address space takes 512MB regardless of how many addresses are present
+type IPSet struct {
+	words  []uint32
+	single bool // Only one goroutine adds and nothing counts meanwhile, Add uses a plain OR
+}
+
+func NewIPSet(words int) *IPSet {
+	return &IPSet{words: make([]uint32, words)}
+}
+
+// Add is safe for concurrent use: the bit is set with an atomic OR, because a plain
+// read-modify-write would lose the bits of other workers updating the same word
+// A set of a single writer skips the locked instruction and sets the bit with a plain OR, it's only
+// created when nothing else reads the words while they're written (see newSet), so that Add is not
+// safe for concurrent use and CountApprox must not be called on it before the writer is done
+func (s *IPSet) Add(ip uint32) {
+	if s.single {
+		s.words[ip>>5] |= 1 << (ip & 31)
+		return
+	}
+	writeIpToUint32Arr(s.words, ip)
+}
+
+func (s *IPSet) AddNew(ip uint32) bool {
+	bit := uint32(1) << (ip & 31)
+	return atomic.OrUint32(&s.words[ip>>5], bit)&bit == 0
+}
+
+func (s *IPSet) Contains(ip uint32) bool {
+	return atomic.LoadUint32(&s.words[ip>>5])&(1<<(ip&31)) != 0
+}
+
+func (s *IPSet) Count() uint64 {
+	return calculateUniqueIpsUint32(s.words)
+}
+
+// CountApprox counts the set while the workers may still be adding to it
+// Every word is read with an atomic load, which pairs with the atomic OR of Add, so the snapshot
+// has no torn reads or data races (the plain OR of a single writer has no such pairing). It's not
+// a single point in time though: the words counted early miss the bits set after they were read,
+// so the result is between the counts at the start and at the end of the call
+func (s *IPSet) CountApprox() uint64 {
+	var count uint64 = 0
+	for i := range s.words {
+		count += uint64(bits.OnesCount32(atomic.LoadUint32(&s.words[i])))
+	}
+	return count
+}
+
+func (s *IPSet) ForEach(fn func(ip uint32)) {
+	forEachIpUint32Arr(s.words, fn)
+}
+
+// Set which stores every /16 in a roaring container: a sorted array of the low 16 bits while the /16
+// has up to 4096 addresses, a bitmap of 8KB once it has more, so a container never takes more than 8KB
+// Sparse data costs 2 bytes per address, which is less than the 8KB blocks of ipcount.SparseSet when the
+// addresses are spread over many /16s. Every container has its own lock, because the array is rewritten
+type roaringSet struct {
+	containers [ipcount.SPARSE_BLOCKS]roaringContainer
+}
+
+type roaringContainer struct {
+	mu     sync.Mutex
+	values []uint16                            // Sorted low 16 bits of the addresses, nil after the conversion
+	bitmap *[ipcount.SPARSE_BLOCK_WORDS]uint32 // Bits of the /16 once it has more than ROARING_ARRAY_MAX addresses
+}
+
+func newRoaringSet() *roaringSet {
+	return &roaringSet{}
+}
+
+func (s *roaringSet) Add(ip uint32) {
+	c := &s.containers[ip>>16]
+	low := uint16(ip)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.bitmap != nil {
+		c.bitmap[low>>5] |= 1 << (low & 31)
+		return
+	}
+	idx, found := slices.BinarySearch(c.values, low)
+	if found {
+		return
+	}
+	if len(c.values) < ROARING_ARRAY_MAX {
+		c.values = slices.Insert(c.values, idx, low)
+		return
+	}
+	c.bitmap = new([ipcount.SPARSE_BLOCK_WORDS]uint32)
+	for _, value := range c.values {
+		c.bitmap[value>>5] |= 1 << (value & 31)
+	}
+	c.bitmap[low>>5] |= 1 << (low & 31)
+	c.values = nil
+}
+
+func (s *roaringSet) Contains(ip uint32) bool {
+	c := &s.containers[ip>>16]
+	low := uint16(ip)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.bitmap != nil {
+		return c.bitmap[low>>5]&(1<<(low&31)) != 0
+	}
+	_, found := slices.BinarySearch(c.values, low)
+	return found
+}
+
+func (s *roaringSet) Count() uint64 {
+	var count uint64 = 0
+	for i := range s.containers {
+		count += s.containers[i].count()
+	}
+	return count
+}
+
+// CountApprox locks the containers one by one, so like IPSet.CountApprox it's not a single point in time
+func (s *roaringSet) CountApprox() uint64 {
+	var count uint64 = 0
+	for i := range s.containers {
+		c := &s.containers[i]
+		c.mu.Lock()
+		count += c.count()
+		c.mu.Unlock()
+	}
+	return count
+}
+
+func (c *roaringContainer) count() uint64 {
+	if c.bitmap != nil {
+		return calculateUniqueIpsUint32(c.bitmap[:])
+	}
+	return uint64(len(c.values))
+}
+
+func (s *roaringSet) ForEach(fn func(ip uint32)) {
+	for i := range s.containers {
+		c := &s.containers[i]
+		high := uint32(i) << 16
+		if c.bitmap != nil {
+			forEachIpUint32Arr(c.bitmap[:], func(low uint32) {
+				fn(high | low)
+			})
+			continue
+		}
+		for _, low := range c.values {
+			fn(high | uint32(low))
+		}
+	}
+}
+
+// Function which returns the number of array and bitmap containers
+func (s *roaringSet) containerCount() (arrays int, bitmaps int) {
+	for i := range s.containers {
+		if s.containers[i].bitmap != nil {
+			bitmaps++
+		} else if len(s.containers[i].values) > 0 {
+			arrays++
+		}
+	}
+	return arrays, bitmaps
+}
+
+// Set which keeps the addresses in hash maps, for inputs with few unique addresses
+// A map entry costs tens of bytes, so it's the smallest set only up to around a million addresses
+// The addresses are spread over HASH_SHARDS maps with their own locks to reduce the contention of the workers
+type hashSet struct {
+	shards [HASH_SHARDS]hashShard
+}
+
+type hashShard struct {
+	mu  sync.Mutex
+	ips map[uint32]struct{}
+}
+
+func newHashSet() *hashSet {
+	s := &hashSet{}
+	for i := range s.shards {
+		s.shards[i].ips = map[uint32]struct{}{}
+	}
+	return s
+}
+
+// Function which returns the shard of the address
+// The address is mixed first, otherwise the addresses of one network would all land in the same shard
+func (s *hashSet) shard(ip uint32) *hashShard {
+	return &s.shards[(ip*0x9E3779B1)>>24]
+}
+
+func (s *hashSet) Add(ip uint32) {
+	shard := s.shard(ip)
+	shard.mu.Lock()
+	shard.ips[ip] = struct{}{}
+	shard.mu.Unlock()
+}
+
+func (s *hashSet) Contains(ip uint32) bool {
+	shard := s.shard(ip)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	_, ok := shard.ips[ip]
+	return ok
+}
+
+func (s *hashSet) Count() uint64 {
+	return s.CountApprox()
+}
+
+func (s *hashSet) CountApprox() uint64 {
+	var count uint64 = 0
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+		count += uint64(len(s.shards[i].ips))
+		s.shards[i].mu.Unlock()
+	}
+	return count
+}
+
+// ForEach sorts the addresses first, the outputs expect the ascending order of the bitsets
+func (s *hashSet) ForEach(fn func(ip uint32)) {
+	all := make([]uint32, 0, s.Count())
+	for i := range s.shards {
+		for ip := range s.shards[i].ips {
+			all = append(all, ip)
+		}
+	}
+	slices.Sort(all)
+	for _, ip := range all {
+		fn(ip)
+	}
+}
+
+// Set which only estimates the number of distinct addresses with a HyperLogLog sketch (64KB, ~0.8% error)
+// It can't look up or list the addresses, so Contains and ForEach find nothing; it's used only by -approx,
+// which can't be combined with the outputs of the addresses
+type approxSet struct {
+	sketch *hyperLogLog
+}
+
+func newApproxSet() *approxSet {
+	return &approxSet{sketch: newHyperLogLog()}
+}
+
+func (s *approxSet) Add(ip uint32) {
+	s.sketch.add(ip)
+}
+
+func (s *approxSet) Contains(ip uint32) bool {
+	return false
+}
+
+func (s *approxSet) Count() uint64 {
+	return s.sketch.count()
+}
+
+func (s *approxSet) CountApprox() uint64 {
+	return s.sketch.count()
+}
+
+func (s *approxSet) ForEach(fn func(ip uint32)) {}
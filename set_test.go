@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"Lightspeed_Task/ipcount"
+)
+
+// Function which returns n random addresses of a fixed seed, so the runs compare the same input
+func randomIps(n int, seed uint64) []uint32 {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	ips := make([]uint32, n)
+	for i := range ips {
+		ips[i] = rng.Uint32()
+	}
+	return ips
+}
+
+// Many goroutines add overlapping addresses, which share the words of the array, to one set
+// Run under -race: a plain read-modify-write in Add loses the bits of the other goroutines and is reported
+func TestConcurrentAdd(t *testing.T) {
+	const goroutines = 32
+	const perGoroutine = 20000
+	sets := []struct {
+		name string
+		set  Set
+	}{
+		{"array", NewIPSet(POW2_27 >> 8)},
+		{"sparse", ipcount.NewSparseSet()},
+		{"roaring", newRoaringSet()},
+		{"hashset", newHashSet()},
+	}
+	for _, test := range sets {
+		t.Run(test.name, func(t *testing.T) {
+			wg := sync.WaitGroup{}
+			for g := range goroutines {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					// every goroutine overlaps half of the addresses of the next one, and neighbouring
+					// addresses land in the same word
+					for i := range perGoroutine {
+						test.set.Add(uint32(g*perGoroutine/2 + i))
+					}
+				}()
+			}
+			wg.Wait()
+
+			want := uint64((goroutines + 1) * perGoroutine / 2)
+			if count := test.set.Count(); count != want {
+				t.Errorf("count = %d, want %d", count, want)
+			}
+			for _, ip := range []uint32{0, perGoroutine, uint32(want - 1)} {
+				if !test.set.Contains(ip) {
+					t.Errorf("%d is missing", ip)
+				}
+			}
+			if test.set.Contains(uint32(want)) {
+				t.Errorf("%d was never added", want)
+			}
+		})
+	}
+}
+
+func TestSingleWriterAddMatchesAtomicAdd(t *testing.T) {
+	addresses := randomIps(1<<16, 1)
+	atomicSet := NewIPSet(POW2_27 >> 12)
+	singleSet := NewIPSet(POW2_27 >> 12)
+	singleSet.single = true
+	for _, ip := range addresses {
+		atomicSet.Add(ip >> 12)
+		singleSet.Add(ip >> 12)
+	}
+	if !slices.Equal(atomicSet.words, singleSet.words) {
+		t.Fatal("the plain OR of a single writer set other bits than the atomic OR")
+	}
+}
+
+func TestNewSetSingleWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		single bool
+	}{
+		{"one thread", Config{numThreads: 1}, true},
+		{"more threads", Config{numThreads: 2}, false},
+		{"progress snapshots", Config{numThreads: 1, progressPath: "fd:2"}, false},
+		{"watch snapshots", Config{numThreads: 1, watch: 1}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.config.backend = "array"
+			test.config.networkBits = 16
+			set, err := newSet(test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if single := set.(*IPSet).single; single != test.single {
+				t.Errorf("single = %v, want %v", single, test.single)
+			}
+		})
+	}
+
+	// a count with -t 1 adds with the plain OR, also on unix where SIGUSR1 is handled
+	path := writeTestFile(t, "input.txt", strings.Join(ipLines(1000), "\n")+"\n")
+	config := testConfig(path)
+	config.backend, config.networkBits = "array", 24
+	result := mustCount(t, config)
+	if dense, ok := ips.(*IPSet); !ok || !dense.single || result.Unique != 4 {
+		t.Errorf("-t 1 counted %d /24s with a %T, want 4 with the single writer array", result.Unique, ips)
+	}
+}
+
+// Plain OR of a single writer against the atomic OR, on random addresses over the full space
+// (a cache miss per address) and on addresses whose words stay in the cache
+func BenchmarkIPSetAdd(b *testing.B) {
+	addresses := randomIps(1<<20, 2)
+	set := NewIPSet(POW2_27)
+	for _, mode := range []struct {
+		name   string
+		single bool
+	}{{"atomic", false}, {"single", true}} {
+		for _, space := range []struct {
+			name  string
+			shift uint
+		}{{"full", 0}, {"cached", 16}} {
+			b.Run(mode.name+"/"+space.name, func(b *testing.B) {
+				set.single = mode.single
+				for i := 0; i < b.N; i++ {
+					set.Add(addresses[i&(len(addresses)-1)] >> space.shift)
+				}
+			})
+		}
+	}
+}
+
+// Final count with plain loads against the live snapshot with an atomic load per word, over the whole 512MB array
+func BenchmarkIPSetCount(b *testing.B) {
+	set := NewIPSet(POW2_27)
+	for _, ip := range randomIps(1<<20, 3) {
+		set.Add(ip)
+	}
+	for _, count := range []struct {
+		name string
+		fn   func() uint64
+	}{{"plain", set.Count}, {"atomic", set.CountApprox}} {
+		b.Run(count.name, func(b *testing.B) {
+			b.SetBytes(POW2_27 * 4)
+			for i := 0; i < b.N; i++ {
+				if n := count.fn(); n == 0 {
+					b.Fatal("empty set")
+				}
+			}
+		})
+	}
+}
+
+// Count of the 32-bit words with a branch over the zero words, the alternative measured against
+// calculateUniqueIpsUint32 in the README
+func countSkippingZeros32(arr []uint32) uint64 {
+	var count uint64
+	for _, w := range arr {
+		if w != 0 {
+			count += uint64(bits.OnesCount32(w))
+		}
+	}
+	return count
+}
+
+// Count of the 32-bit words without the wide view, the loop before wideWords
+func count32(arr []uint32) uint64 {
+	var count uint64
+	for _, w := range arr {
+		count += uint64(bits.OnesCount32(w))
+	}
+	return count
+}
+
+// Iteration of the 32-bit words, skipping 32 zero bits at a time instead of 64
+func forEach32(arr []uint32, fn func(ip uint32)) {
+	for idx, w := range arr {
+		for w != 0 {
+			bit := bits.TrailingZeros32(w)
+			w &= w - 1
+			fn(uint32(idx)<<5 | uint32(bit))
+		}
+	}
+}
+
+// Count and iteration of the full array with the zeros skipped by the 64-bit words, by a branch on the
+// 32-bit words, or not at all, from a nearly empty array to a quarter of the address space
+func BenchmarkCountAndIterate(b *testing.B) {
+	words := make([]uint32, POW2_27)
+	densities := []struct {
+		name string
+		fill func()
+	}{
+		{"64K", func() {
+			for _, ip := range randomIps(1<<16, 7) {
+				writeIpToUint32Arr(words, ip)
+			}
+		}},
+		{"16M", func() {
+			for _, ip := range randomIps(1<<24, 8) {
+				writeIpToUint32Arr(words, ip)
+			}
+		}},
+		{"1G", func() {
+			// every bit set with the probability 1/4
+			rng := rand.New(rand.NewPCG(9, 9))
+			for i := range words {
+				words[i] = rng.Uint32() & rng.Uint32()
+			}
+		}},
+	}
+	var sum uint64
+	for _, density := range densities {
+		density.fill()
+		counts := []struct {
+			name string
+			fn   func([]uint32) uint64
+		}{{"count64", calculateUniqueIpsUint32}, {"count32", count32}, {"count32-skip-zeros", countSkippingZeros32}}
+		for _, count := range counts {
+			b.Run(density.name+"/"+count.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					sum += count.fn(words)
+				}
+			})
+		}
+		iterations := []struct {
+			name string
+			fn   func([]uint32, func(uint32))
+		}{{"iterate64", forEachIpUint32Arr}, {"iterate32", forEach32}}
+		for _, iterate := range iterations {
+			b.Run(density.name+"/"+iterate.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					iterate.fn(words, func(ip uint32) { sum += uint64(ip) })
+				}
+			})
+		}
+	}
+	if sum == 1 {
+		b.Log(sum)
+	}
+}
+
+// With every bit set the count is 2^32, one more than fits in uint32 (it used to wrap to 0)
+func TestFullAddressSpaceCount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocates 512MB")
+	}
+	dense := NewIPSet(POW2_27)
+	for i := range dense.words {
+		dense.words[i] = ^uint32(0)
+	}
+	if count := dense.Count(); count != 1<<32 {
+		t.Errorf("Count() = %d, want 2^32", count)
+	}
+	if count := dense.CountApprox(); count != 1<<32 {
+		t.Errorf("CountApprox() = %d, want 2^32", count)
+	}
+}
+
+// The address is the index of its bit, the word is ip>>5 and the bit ip&31
+func TestAddressWordAndBit(t *testing.T) {
+	tests := []struct {
+		ip   uint32
+		word int
+		bit  uint
+	}{
+		{0x00000000, 0, 0},
+		{0x0A000001, 0x0A000001 >> 5, 1},
+		{0x0A00001F, 0x0A000000 >> 5, 31},
+		{0x0A000020, 0x0A000020 >> 5, 0},
+		{0xFFFFFFFF, POW2_27 - 1, 31},
+	}
+	for _, test := range tests {
+		set := NewIPSet(POW2_27)
+		set.Add(test.ip)
+		if set.words[test.word] != 1<<test.bit || set.Count() != 1 {
+			t.Errorf("%08x set word %d to %08x, want bit %d", test.ip, test.word, set.words[test.word], test.bit)
+		}
+		found := []uint32{}
+		set.ForEach(func(ip uint32) { found = append(found, ip) })
+		if len(found) != 1 || found[0] != test.ip {
+			t.Errorf("ForEach of %08x gave %x", test.ip, found)
+		}
+	}
+}
+
+// Function which runs fn on each of the inputs in its own goroutine and waits for them
+func runWorkers(inputs [][]uint32, fn func(worker int, ips []uint32)) {
+	wg := sync.WaitGroup{}
+	for worker, ips := range inputs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(worker, ips)
+		}()
+	}
+	wg.Wait()
+}
+
+// The shared array written with atomic ORs against private sets written without atomics (8KB blocks
+// per /16, allocated on first use) which are ORed into the array at the end, with 4 workers adding
+// 2M addresses each drawn from pools of few to many distinct addresses (low to high contention
+// on the same words, high to low cost of the private blocks)
+func BenchmarkSharedVsPrivateSets(b *testing.B) {
+	const workers = 4
+	const perWorker = 2 << 20
+	set := NewIPSet(POW2_27)
+	for _, distinct := range []int{1 << 10, 1 << 16, 1 << 23} {
+		pool := randomIps(distinct, 3)
+		rng := rand.New(rand.NewPCG(4, 4))
+		inputs := make([][]uint32, workers)
+		for w := range inputs {
+			inputs[w] = make([]uint32, perWorker)
+			for i := range inputs[w] {
+				inputs[w][i] = pool[rng.IntN(distinct)]
+			}
+		}
+		perAddress := func(b *testing.B) {
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*workers*perWorker), "ns/address")
+		}
+
+		b.Run(fmt.Sprintf("distinct=%d/atomic", distinct), func(b *testing.B) {
+			for range b.N {
+				runWorkers(inputs, func(_ int, ips []uint32) {
+					for _, ip := range ips {
+						set.Add(ip)
+					}
+				})
+			}
+			perAddress(b)
+		})
+
+		b.Run(fmt.Sprintf("distinct=%d/private", distinct), func(b *testing.B) {
+			b.ReportAllocs()
+			for range b.N {
+				private := make([][]*[ipcount.SPARSE_BLOCK_WORDS]uint32, workers)
+				runWorkers(inputs, func(worker int, ips []uint32) {
+					blocks := make([]*[ipcount.SPARSE_BLOCK_WORDS]uint32, ipcount.SPARSE_BLOCKS)
+					for _, ip := range ips {
+						block := blocks[ip>>16]
+						if block == nil {
+							block = new([ipcount.SPARSE_BLOCK_WORDS]uint32)
+							blocks[ip>>16] = block
+						}
+						block[ip>>5&(ipcount.SPARSE_BLOCK_WORDS-1)] |= 1 << (ip & 31)
+					}
+					private[worker] = blocks
+				})
+				for _, blocks := range private {
+					for idx, block := range blocks {
+						if block == nil {
+							continue
+						}
+						words := set.words[idx*ipcount.SPARSE_BLOCK_WORDS : (idx+1)*ipcount.SPARSE_BLOCK_WORDS]
+						for i, w := range block {
+							words[i] |= w
+						}
+					}
+				}
+			}
+			perAddress(b)
+		})
+	}
+}
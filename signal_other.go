@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// SIGUSR1 doesn't exist outside of unix, so there is no live count dumping
+func handleCountSignal(deferred bool) func() {
+	return func() {}
+}
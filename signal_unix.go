@@ -0,0 +1,49 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Function which prints the current unique count to stderr every time the process receives SIGUSR1
+// The workers keep setting bits while the set is counted, so the snapshot is eventually-consistent:
+// it contains every IP written before the count started and some of those written during it
+// CountApprox reads the words atomically, which pairs with the atomic OR of the workers. The plain OR
+// of a single writer has no such pairing, so with deferred the requests are only acknowledged and
+// the count is printed once, by the returned function which removes the handler after the reading
+func handleCountSignal(deferred bool) func() {
+	sigCh := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	exited := make(chan bool)
+	signal.Notify(sigCh, syscall.SIGUSR1)
+
+	go func() {
+		requested := false
+		for {
+			select {
+			case <-sigCh:
+				if deferred {
+					requested = true
+					fmt.Fprintln(os.Stderr, "Unique ip count is printed once the reading thread is done")
+					continue
+				}
+				fmt.Fprintln(os.Stderr, "Unique ip count (in progress) =", ips.CountApprox())
+			case <-done:
+				exited <- requested
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(done)
+		if <-exited {
+			fmt.Fprintln(os.Stderr, "Unique ip count (requested by SIGUSR1) =", ips.Count())
+		}
+	}
+}
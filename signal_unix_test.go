@@ -0,0 +1,64 @@
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// Function which sends SIGUSR1 to the process and returns the line the handler printed to stderr
+func signalCount(t *testing.T, stderr *bufio.Reader) string {
+	t.Helper()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	line, err := stderr.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(line)
+}
+
+// The count of a single writer isn't read while it's written: SIGUSR1 is acknowledged and the count
+// is printed when the handler is removed after the reading, the atomic set is counted right away
+// Run under -race: a snapshot of the plain OR during the writes is reported
+func TestCountSignal(t *testing.T) {
+	for _, single := range []bool{true, false} {
+		set := NewIPSet(POW2_27 >> 8)
+		set.single = single
+		ips = set
+		read, write, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stderr := os.Stderr
+		os.Stderr = write
+		stop := handleCountSignal(single)
+
+		stream := bufio.NewReader(read)
+		set.Add(1)
+		first := signalCount(t, stream)
+		set.Add(2)
+		second := signalCount(t, stream)
+		set.Add(3)
+		stop()
+		write.Close()
+		os.Stderr = stderr
+		rest, _ := stream.ReadString(0)
+		read.Close()
+
+		if single {
+			ack := "Unique ip count is printed once the reading thread is done"
+			if first != ack || second != ack || rest != "Unique ip count (requested by SIGUSR1) = 3\n" {
+				t.Errorf("single writer printed %q, %q and %q", first, second, rest)
+			}
+		} else if first != "Unique ip count (in progress) = 1" || second != "Unique ip count (in progress) = 2" || rest != "" {
+			t.Errorf("atomic set printed %q, %q and %q", first, second, rest)
+		}
+	}
+	ips = nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Special-use IPv4 networks of the IANA registry (RFC 6890 and its updates), in ascending order
+// The networks don't overlap except the broadcast address, which is listed before the reserved /4
+// holding it and wins as the first match
+var specialUseNetworks = []struct {
+	cidr     string
+	category string
+}{
+	{"0.0.0.0/8", "this-network"},
+	{"10.0.0.0/8", "private"},
+	{"100.64.0.0/10", "shared"},
+	{"127.0.0.0/8", "loopback"},
+	{"169.254.0.0/16", "link-local"},
+	{"172.16.0.0/12", "private"},
+	{"192.0.0.0/24", "ietf-protocol"},
+	{"192.0.2.0/24", "documentation"},
+	{"192.88.99.0/24", "6to4-relay"},
+	{"192.168.0.0/16", "private"},
+	{"198.18.0.0/15", "benchmarking"},
+	{"198.51.100.0/24", "documentation"},
+	{"203.0.113.0/24", "documentation"},
+	{"224.0.0.0/4", "multicast"},
+	{"255.255.255.255/32", "broadcast"},
+	{"240.0.0.0/4", "reserved"},
+}
+
+// Categories of the special-use networks in the report order, the routable addresses are public
+var specialUseCategories = []string{
+	"this-network", "private", "shared", "loopback", "link-local", "ietf-protocol", "documentation",
+	"6to4-relay", "benchmarking", "multicast", "reserved", "broadcast", "public",
+}
+
+// Unique IPs of one special-use category
+type CategoryCount struct {
+	Category string `json:"category"`
+	Count    uint64 `json:"count"`
+}
+
+// Function which counts the unique addresses of every special-use category and the public rest
+// The networks of the table are parsed by the CIDRParser of -cidr, with -network-bits every network
+// is counted by its first address like in classCounts
+func specialUseCounts(set Set, networkBits int) []CategoryCount {
+	type network struct {
+		first, last uint32
+		category    int
+	}
+	networks := []network{}
+	for _, special := range specialUseNetworks {
+		first, last, ok := (CIDRParser{Address: DottedQuadParser{Strict: true}}).ParseRange([]byte(special.cidr))
+		if !ok {
+			panic(fmt.Sprintf("invalid special-use network %q", special.cidr))
+		}
+		networks = append(networks, network{first, last, slices.Index(specialUseCategories, special.category)})
+	}
+	public := len(specialUseCategories) - 1
+
+	counts := make([]CategoryCount, len(specialUseCategories))
+	for i, category := range specialUseCategories {
+		counts[i].Category = category
+	}
+	shift := uint(32 - networkBits)
+	set.ForEach(func(network uint32) {
+		ip := network << shift
+		category := public
+		for _, special := range networks {
+			if ip >= special.first && ip <= special.last {
+				category = special.category
+				break
+			}
+		}
+		counts[category].Count++
+	})
+	return counts
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// Function which prints the version and the build metadata recorded by the Go toolchain
+// go build inside the git checkout derives the version from the commit (v0.0.0-<time>-<revision>, +dirty
+// with local changes) and embeds the VCS revision and its commit time, go run and builds outside of the
+// checkout have only "(devel)" and the Go version. The build time itself isn't recorded by the toolchain
+func printVersion(w io.Writer) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		fmt.Fprintln(w, "unique-ip-counter (no build info)")
+		fmt.Fprintln(w, "go:", runtime.Version())
+		return
+	}
+
+	fmt.Fprintln(w, "unique-ip-counter", info.Main.Version)
+	fmt.Fprintln(w, "go:", info.GoVersion, runtime.GOOS+"/"+runtime.GOARCH)
+	settings := map[string]string{}
+	for _, setting := range info.Settings {
+		settings[setting.Key] = setting.Value
+	}
+	if revision := settings["vcs.revision"]; revision != "" {
+		if settings["vcs.modified"] == "true" {
+			revision += " (modified)"
+		}
+		fmt.Fprintln(w, "revision:", revision)
+	}
+	if commitTime := settings["vcs.time"]; commitTime != "" {
+		fmt.Fprintln(w, "commit time:", commitTime)
+	}
+}
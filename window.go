@@ -0,0 +1,52 @@
+package main
+
+import "time"
+
+const WINDOW_SLOTS = 16 // Number of time slots of the rolling window, the window moves in steps of window/WINDOW_SLOTS
+
+// Approximate counter of the distinct IP addresses seen in the last window of time
+// The window is split into time slots, every slot has its own HyperLogLog sketch and the slots form a ring,
+// so the sketch of a slot which fell out of the window is cleared and reused for the current slot
+// The distinct count of the window is the count of the sketches merged by the register-wise maximum
+// Used by the single-threaded follow mode, so it's not safe for concurrent use
+type windowCounter struct {
+	slotLen time.Duration              // Length of one time slot
+	slots   [WINDOW_SLOTS]*hyperLogLog // Sketches of the slots, indexed by the slot number modulo WINDOW_SLOTS
+	numbers [WINDOW_SLOTS]int64        // Slot number (time / slotLen) which the sketch currently holds
+}
+
+func newWindowCounter(window time.Duration) *windowCounter {
+	w := &windowCounter{slotLen: max(window/WINDOW_SLOTS, time.Nanosecond)}
+	for i := range w.slots {
+		w.slots[i] = newHyperLogLog()
+		w.numbers[i] = -1
+	}
+	return w
+}
+
+// Function which adds the IP address seen at the given time to its slot
+func (w *windowCounter) add(ip uint32, now time.Time) {
+	number := now.UnixNano() / int64(w.slotLen)
+	idx := number % WINDOW_SLOTS
+	if w.numbers[idx] != number {
+		clear(w.slots[idx].registers)
+		w.numbers[idx] = number
+	}
+	w.slots[idx].add(ip)
+}
+
+// Function which estimates the number of distinct IP addresses seen in the last window before now
+// Slots are whole, so the counted span is between window-slotLen and window long
+func (w *windowCounter) count(now time.Time) uint64 {
+	number := now.UnixNano() / int64(w.slotLen)
+	merged := newHyperLogLog()
+	for i, sketch := range w.slots {
+		if w.numbers[i] <= number-WINDOW_SLOTS {
+			continue
+		}
+		for r, rank := range sketch.registers {
+			merged.registers[r] = max(merged.registers[r], rank)
+		}
+	}
+	return merged.count()
+}
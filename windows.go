@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var timeWindows *bitsetMap     // Unique IPs of every -window-size window, nil unless -windows-csv is set
+var timeParser TimeLineParser  // Parser of the line times, set together with timeWindows
+var windowSize time.Duration   // Length of the windows
+var untimedLines atomic.Uint64 // Counted lines without a time, they're in no window
+
+// Function which adds the IP of the line to the window of the line's time
+// The key of the window is the number of whole windows since the Unix epoch
+func addToWindow(line []byte, network uint32) {
+	t, ok := timeParser.ParseTime(line)
+	if !ok || t.Unix() < 0 {
+		untimedLines.Add(1)
+		return
+	}
+	timeWindows.add(uint64(t.UnixNano()/int64(windowSize)), network)
+}
+
+// Function which writes the unique count of every window as CSV, one row per window in time order
+// window_start and window_end are RFC 3339 UTC times, the end is exclusive
+// The rows go through encoding/csv, which quotes the fields when they need it
+func writeWindowsCsv(name string, windows *bitsetMap, size time.Duration) (int, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	buffered := bufio.NewWriterSize(file, BUFFER_SIZE)
+	writer := csv.NewWriter(buffered)
+	writer.Write([]string{"window_start", "window_end", "unique"})
+	keys := windows.keys()
+	for _, key := range keys {
+		start := time.Unix(0, int64(key)*int64(size)).UTC()
+		writer.Write([]string{
+			start.Format(time.RFC3339),
+			start.Add(size).Format(time.RFC3339),
+			strconv.FormatUint(windows.bucket(key).Count(), 10),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return 0, err
+	}
+	if err := buffered.Flush(); err != nil {
+		return 0, err
+	}
+	return len(keys), file.Close()
+}